@@ -1,7 +1,9 @@
 package impl
 
 import (
+	"fmt"
 	"reflect"
+	"strconv"
 )
 
 // LudwiegTypeAnnotation is used to annotate interface types based on indexed
@@ -11,6 +13,30 @@ type LudwiegTypeAnnotation struct {
 	ArrayType     ProtocolType
 	ArraySize     string
 	ArrayUserType reflect.Type
+
+	// ArrayElem describes items of arrays holding other arrays. When set, it
+	// takes precedence over ArrayType.
+	ArrayElem *LudwiegTypeAnnotation
+
+	// ArrayCompact omits the item count of fixed-size arrays, since it is
+	// already known by the schema.
+	ArrayCompact bool
+
+	MapKeyType       ProtocolType
+	MapValueType     ProtocolType
+	MapValueUserType reflect.Type
+
+	// Tag retains the field number of the annotated field. Structs whose
+	// fields declare tags are encoded with them, allowing fields to be
+	// added, removed or reordered without breaking other peers. Either all
+	// fields of a struct declare an unique, nonzero tag, or none does.
+	Tag uint16
+}
+
+// WithTag returns a copy of the annotation using the provided field number
+func (t LudwiegTypeAnnotation) WithTag(tag uint16) LudwiegTypeAnnotation {
+	t.Tag = tag
+	return t
 }
 
 func (t LudwiegTypeAnnotation) metaProtocolByte() *metaProtocolByte {
@@ -18,14 +44,67 @@ func (t LudwiegTypeAnnotation) metaProtocolByte() *metaProtocolByte {
 	return &p
 }
 
-// ArrayOf creates a new array annotation with no predefined length
+// fixedArraySize returns the amount of items declared by ArraySize, or -1 for
+// arrays without a predefined size.
+func (t LudwiegTypeAnnotation) fixedArraySize() (int, error) {
+	if t.ArraySize == "*" {
+		return -1, nil
+	}
+	size, err := strconv.Atoi(t.ArraySize)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid array size %s", t.ArraySize)
+	}
+	return size, nil
+}
+
+// checkArraySize returns an error in case an array with the provided amount
+// of items does not match the size declared by ArraySize.
+func (t LudwiegTypeAnnotation) checkArraySize(items int) error {
+	size, err := t.fixedArraySize()
+	if err != nil {
+		return err
+	}
+	if size >= 0 && items != size {
+		return fmt.Errorf("fixed-size array expects %d items, found %d", size, items)
+	}
+	return nil
+}
+
+// arrayElem returns the annotation describing items of an array, either
+// provided through ArrayElem, or by ArrayType and ArrayUserType.
+func (t LudwiegTypeAnnotation) arrayElem() *LudwiegTypeAnnotation {
+	if t.ArrayElem != nil {
+		return t.ArrayElem
+	}
+	return &LudwiegTypeAnnotation{Type: t.ArrayType}
+}
+
+// ArrayOf creates a new array annotation with no predefined length. Items are
+// described by r, which may be a ProtocolType, another annotation (such as one
+// created by ArrayOf, for arrays of arrays), or a structure.
 func ArrayOf(r interface{}) LudwiegTypeAnnotation {
 	return ArrayOfWithSize(r, "*")
 }
 
 // ArrayOfWithSize creates a new array annotation using the provided size
-// as the predefined size information.
+// as the predefined size information. Items are described by r, just like
+// ArrayOf.
 func ArrayOfWithSize(r interface{}, size string) LudwiegTypeAnnotation {
+	switch elem := r.(type) {
+	case ProtocolType:
+		return LudwiegTypeAnnotation{
+			Type:      TypeArray,
+			ArrayType: elem,
+			ArraySize: size,
+		}
+	case LudwiegTypeAnnotation:
+		return LudwiegTypeAnnotation{
+			Type:      TypeArray,
+			ArrayType: elem.Type,
+			ArraySize: size,
+			ArrayElem: &elem,
+		}
+	}
 	return LudwiegTypeAnnotation{
 		Type:          TypeArray,
 		ArrayType:     TypeStruct,
@@ -33,3 +112,23 @@ func ArrayOfWithSize(r interface{}, size string) LudwiegTypeAnnotation {
 		ArrayUserType: reflect.PtrTo(reflect.TypeOf(r)),
 	}
 }
+
+// MapOf creates a new map annotation using the provided key and value types
+func MapOf(key, value ProtocolType) LudwiegTypeAnnotation {
+	return LudwiegTypeAnnotation{
+		Type:         TypeMap,
+		MapKeyType:   key,
+		MapValueType: value,
+	}
+}
+
+// MapOfStruct creates a new map annotation using the provided key type, and
+// values of the same type as the provided structure.
+func MapOfStruct(key ProtocolType, r interface{}) LudwiegTypeAnnotation {
+	return LudwiegTypeAnnotation{
+		Type:             TypeMap,
+		MapKeyType:       key,
+		MapValueType:     TypeStruct,
+		MapValueUserType: reflect.PtrTo(reflect.TypeOf(r)),
+	}
+}
@@ -0,0 +1,244 @@
+package impl
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// decodeContext retains state shared among decoders while a buffer is being
+// deserialised.
+type decodeContext struct {
+	opts DeserializerOptions
+
+	// base is the absolute offset, within the payload, of the buffer
+	// currently being decoded.
+	base int
+
+	// path retains names of fields and array indexes being decoded.
+	path []string
+
+	// types retains the Go type expected for each entry in path, or nil when
+	// it is not known.
+	types []reflect.Type
+
+	// annotations retains the annotation describing each entry in path, or
+	// nil when it is not known.
+	annotations []*LudwiegTypeAnnotation
+}
+
+func newDecodeContext(opts DeserializerOptions, t reflect.Type) *decodeContext {
+	return &decodeContext{
+		opts:        opts.withDefaults(),
+		path:        []string{""},
+		types:       []reflect.Type{t},
+		annotations: []*LudwiegTypeAnnotation{nil},
+	}
+}
+
+// push enters the value named name, expected to be of type t and described by
+// annotation. Values are always entered, so each push must be followed by a
+// pop, but an error is returned in case they are nested deeper than allowed by
+// MaxDepth.
+func (ctx *decodeContext) push(name string, t reflect.Type, annotation *LudwiegTypeAnnotation) error {
+	ctx.path = append(ctx.path, name)
+	ctx.types = append(ctx.types, t)
+	ctx.annotations = append(ctx.annotations, annotation)
+	if depth := uint64(len(ctx.path) - 1); depth > ctx.opts.MaxDepth {
+		return fmt.Errorf("%w: depth of %d exceeds limit of %d", ErrMaxDepth, depth, ctx.opts.MaxDepth)
+	}
+	return nil
+}
+
+func (ctx *decodeContext) pop() {
+	ctx.path = ctx.path[:len(ctx.path)-1]
+	ctx.types = ctx.types[:len(ctx.types)-1]
+	ctx.annotations = ctx.annotations[:len(ctx.annotations)-1]
+}
+
+// current returns the Go type expected for the value being decoded, or nil
+// when it is not known.
+func (ctx *decodeContext) current() reflect.Type {
+	return ctx.types[len(ctx.types)-1]
+}
+
+// structType returns the struct type being decoded, or nil when it is not
+// known.
+func (ctx *decodeContext) structType() reflect.Type {
+	t := ctx.current()
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil
+	}
+	return t
+}
+
+// field returns the name, Go type and annotation of the i-th field of the
+// struct being decoded. An empty name is returned when it is not known.
+func (ctx *decodeContext) field(i int) (string, reflect.Type, *LudwiegTypeAnnotation) {
+	t := ctx.structType()
+	if t == nil {
+		return "", nil, nil
+	}
+	// Plans skip fields that are not described by annotations.
+	var annotation *LudwiegTypeAnnotation
+	if plan, err := planFor(t); err == nil {
+		if i >= len(plan.fields) {
+			return "", nil, nil
+		}
+		annotation = &plan.fields[i].annotation
+		i = plan.fields[i].index
+	}
+	if i >= t.NumField() {
+		return "", nil, nil
+	}
+	f := t.Field(i)
+	return f.Name, f.Type, annotation
+}
+
+// taggedField returns the name, Go type and annotation of the field of the
+// struct being decoded identified by tag. An empty name is returned when it is
+// not known.
+func (ctx *decodeContext) taggedField(tag uint16) (string, reflect.Type, *LudwiegTypeAnnotation) {
+	t := ctx.structType()
+	if t == nil {
+		return "", nil, nil
+	}
+	plan, err := planFor(t)
+	if err != nil {
+		return "", nil, nil
+	}
+	i, ok := plan.tags[tag]
+	if !ok {
+		return "", nil, nil
+	}
+	f := t.Field(plan.fields[i].index)
+	return f.Name, f.Type, &plan.fields[i].annotation
+}
+
+// retainsUnknownFields reports whether the struct being decoded retains
+// fields unknown to it through a LudwiegUnknownFields field.
+func (ctx *decodeContext) retainsUnknownFields() bool {
+	t := ctx.structType()
+	if t == nil {
+		return false
+	}
+	plan, err := planFor(t)
+	return err == nil && plan.unknown >= 0
+}
+
+// item returns the Go type and annotation of items of the array, or values of
+// the map being decoded, either of which is nil when not known.
+func (ctx *decodeContext) item() (reflect.Type, *LudwiegTypeAnnotation) {
+	var annotation *LudwiegTypeAnnotation
+	// Map values are never collections, hence their annotations are not
+	// needed.
+	if a := ctx.annotations[len(ctx.annotations)-1]; a != nil && a.Type == TypeArray {
+		annotation = a.arrayElem()
+	}
+	t := ctx.current()
+	if t == nil || (t.Kind() != reflect.Slice && t.Kind() != reflect.Map) {
+		return nil, annotation
+	}
+	return t.Elem(), annotation
+}
+
+// compactArray reports whether the array being decoded is declared by the
+// schema as a compact fixed-size array, omitting its item count.
+func (ctx *decodeContext) compactArray() bool {
+	a := ctx.annotations[len(ctx.annotations)-1]
+	if a == nil || a.Type != TypeArray || !a.ArrayCompact {
+		return false
+	}
+	size, err := a.fixedArraySize()
+	return err == nil && size >= 0
+}
+
+func (ctx *decodeContext) pathString() string {
+	var path strings.Builder
+	for _, p := range ctx.path {
+		if p == "" {
+			continue
+		}
+		if path.Len() > 0 && !strings.HasPrefix(p, "[") {
+			path.WriteByte('.')
+		}
+		path.WriteString(p)
+	}
+	return path.String()
+}
+
+// error wraps err into a DecodeError describing the value of type t being
+// decoded at offset.
+func (ctx *decodeContext) error(t ProtocolType, offset int, err error) error {
+	if _, ok := err.(*DecodeError); ok {
+		return err
+	}
+	return &DecodeError{
+		Offset: ctx.base + offset,
+		Type:   t,
+		Path:   ctx.pathString(),
+		Err:    err,
+	}
+}
+
+// read returns the next n bytes of b, advancing offset. A DecodeError is
+// returned in case b does not have enough remaining bytes.
+func (ctx *decodeContext) read(t ProtocolType, b []byte, offset *int, n uint64) ([]byte, error) {
+	if *offset > len(b) || uint64(len(b)-*offset) < n {
+		return nil, ctx.error(t, *offset, io.ErrUnexpectedEOF)
+	}
+	start := *offset
+	incrSize(offset, int(n))
+	return b[start:*offset], nil
+}
+
+// readSize reads a length prefix for a value of type t, advancing offset.
+func (ctx *decodeContext) readSize(t ProtocolType, b []byte, offset *int) (uint64, error) {
+	start := *offset
+	encoding, err := ctx.read(t, b, offset, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	var size []byte
+	switch lengthEncoding(encoding[0]) {
+	case lengthEncodingEmpty:
+		return 0, nil
+	case lengthEncodingUint8:
+		size, err = ctx.read(t, b, offset, 1)
+	case lengthEncodingUint16:
+		size, err = ctx.read(t, b, offset, 2)
+	case lengthEncodingUint32:
+		size, err = ctx.read(t, b, offset, 4)
+	case lengthEncodingUint64:
+		size, err = ctx.read(t, b, offset, 8)
+	default:
+		return 0, ctx.error(t, start, fmt.Errorf("%w %#v", ErrBadLengthPrefix, encoding[0]))
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	switch len(size) {
+	case 1:
+		return uint64(size[0]), nil
+	case 2:
+		return uint64(readUint16(size)), nil
+	case 4:
+		return uint64(readUint32(size)), nil
+	default:
+		return readUint64(size), nil
+	}
+}
+
+// deserializeAt deserializes buffer, which starts at offset start of the
+// buffer currently being decoded.
+func (ctx *decodeContext) deserializeAt(buffer []byte, start int) ([]interface{}, error) {
+	ctx.base += start
+	defer func() { ctx.base -= start }()
+	return deserialize(ctx, buffer)
+}
@@ -0,0 +1,70 @@
+package impl
+
+import (
+	"io"
+)
+
+const decoderBufferSize = 4096
+
+// Decoder reads and decodes messages from an input stream. It buffers reads
+// internally and drives a Deserializer, so callers are not required to feed
+// bytes one by one.
+type Decoder struct {
+	r       io.Reader
+	d       *Deserializer
+	buf     []byte
+	pending []*DeserializationCandidate
+	err     error
+}
+
+// NewDecoder returns a new Decoder that reads from r. The Decoder introduces
+// its own buffering and may read data from r beyond the messages requested.
+func NewDecoder(r io.Reader) *Decoder {
+	return NewDecoderWithOptions(r, DeserializerOptions{})
+}
+
+// NewDecoderWithOptions returns a new Decoder that reads from r, enforcing the
+// provided options.
+func NewDecoderWithOptions(r io.Reader, opts DeserializerOptions) *Decoder {
+	return &Decoder{
+		r:   r,
+		d:   NewDeserializer(opts),
+		buf: make([]byte, decoderBufferSize),
+	}
+}
+
+// Decode reads the next message from its input and returns the deserialized
+// package along with its metadata. io.EOF is returned when the input ends
+// between messages, and io.ErrUnexpectedEOF when it ends in the middle of
+// one. Messages dropped by the underlying Deserializer are reported through
+// the returned error, and decoding may be resumed afterwards.
+func (dec *Decoder) Decode() (interface{}, *MessageMetadata, error) {
+	for len(dec.pending) == 0 {
+		if dec.err != nil {
+			if dec.err == io.EOF && dec.d.inProgress() {
+				return nil, nil, io.ErrUnexpectedEOF
+			}
+			return nil, nil, dec.err
+		}
+
+		n, err := dec.r.Read(dec.buf)
+		dropped := dec.d.droppedFrames
+		dec.pending, _ = dec.d.FeedBytes(dec.buf[:n])
+		dec.err = err
+		if dec.d.droppedFrames != dropped {
+			return nil, nil, dec.d.lastError
+		}
+	}
+
+	c := dec.pending[0]
+	dec.pending = dec.pending[1:]
+	return dec.deserialize(c)
+}
+
+func (dec *Decoder) deserialize(c *DeserializationCandidate) (interface{}, *MessageMetadata, error) {
+	v, err := c.Deserialize()
+	if err != nil {
+		return nil, c.MessageMeta, err
+	}
+	return v, c.MessageMeta, nil
+}
@@ -2,6 +2,7 @@ package impl
 
 import (
 	"fmt"
+	"io"
 	"reflect"
 )
 
@@ -10,13 +11,14 @@ import (
 type DeserializationCandidate struct {
 	MessageMeta *MessageMetadata
 	buffer      []byte
+	opts        DeserializerOptions
 }
 
 // CanDeserialize may be used to check if a received package can be deserialized
 // beforehand. This is useful when supporting several packages across different
 // platforms.
 func (c *DeserializationCandidate) CanDeserialize() bool {
-	_, ok := registeredPackages[c.MessageMeta.PackageType]
+	_, ok := c.opts.registry().lookup(c.MessageMeta.PackageType)
 	return ok
 }
 
@@ -24,119 +26,368 @@ func (c *DeserializationCandidate) CanDeserialize() bool {
 // the language's facilities to determine which type was returned, and act
 // accordingly.
 func (c *DeserializationCandidate) Deserialize() (interface{}, error) {
-	if !c.CanDeserialize() {
-		panic(fmt.Errorf("cannot deserialize unknown package %#v", c.MessageMeta.PackageType))
+	packageMeta, ok := c.opts.registry().lookup(c.MessageMeta.PackageType)
+	if !ok {
+		return nil, fmt.Errorf("%w %#v", ErrUnknownPackage, c.MessageMeta.PackageType)
 	}
 
-	deserializationResult, err := deserialize(c.buffer)
-	if err != nil {
-		return nil, err
-	}
+	ctx := newDecodeContext(c.opts, packageMeta.nativeType)
 
-	packageMeta := registeredPackages[c.MessageMeta.PackageType]
-	packageType := packageMeta.nativeType
+	return unmarshalStruct(ctx, packageMeta.plan, c.buffer, 0)
+}
 
-	return createObjectFromType(packageMeta.meta, packageType, deserializationResult), nil
+func schemaMismatchError(t reflect.Type, field string, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s: %s", ErrSchemaMismatch, qualifiedName(t, field), fmt.Sprintf(format, args...))
 }
 
-func createObjectFromType(annotations []LudwiegTypeAnnotation, t reflect.Type, values []interface{}) interface{} {
+// qualifiedName returns the name of a field prefixed by the name of the type
+// it belongs to, when known.
+func qualifiedName(t reflect.Type, field string) string {
+	var name string
+	if t != nil {
+		name = t.Name()
+	}
+	if field != "" && name != "" {
+		name += "." + field
+	} else if field != "" {
+		name = field
+	}
+	return name
+}
+
+// setDecodedValue sets a decoded value into a field, as long as their types
+// are compatible.
+func setDecodedValue(t reflect.Type, field reflect.StructField, fieldValue, rawPointer reflect.Value) error {
+	if !rawPointer.Type().AssignableTo(field.Type) {
+		return schemaMismatchError(t, field.Name, "cannot assign %s to %s", rawPointer.Type(), field.Type)
+	}
+	fieldValue.Set(rawPointer)
+	return nil
+}
+
+func createObjectFromPlan(plan *structPlan, values []interface{}) (interface{}, error) {
+	t := plan.typ
+	values, unknown, err := valuesByPosition(plan, values)
+	if err != nil {
+		return nil, err
+	}
 
 	resultLen := len(values)
 
 	instance := reflect.New(t)
 	ptr := reflect.Indirect(instance)
 
-	for i, fieldMeta := range annotations {
-		if i > resultLen {
+	for i := range plan.fields {
+		if i >= resultLen {
 			break
 		}
+		fieldPlan := &plan.fields[i]
+		fieldMeta := fieldPlan.annotation
 		rawValue := values[i]
 		rawPointer := reflect.ValueOf(rawValue)
 
-		field := t.Field(i)
-		fieldValue := ptr.Field(i)
+		field := t.Field(fieldPlan.index)
+		fieldValue := ptr.Field(fieldPlan.index)
 
 		switch fieldMeta.Type {
-		case TypeUint8, TypeUint32, TypeUint64, TypeDouble, TypeString, TypeBool, TypeUUID, TypeAny, TypeDynInt:
-			if rawPointer.IsNil() {
+		case TypeUint8, TypeUint16, TypeUint32, TypeUint64, TypeInt8, TypeInt16, TypeInt32, TypeInt64,
+			TypeFloat, TypeDouble, TypeString, TypeBool, TypeUUID, TypeAny, TypeDynInt,
+			TypeTimestamp, TypeDuration:
+			if rawValue == nil || rawPointer.IsNil() {
 				continue
 			}
-			fieldValue.Set(rawPointer)
+			if err := setDecodedValue(t, field, fieldValue, rawPointer); err != nil {
+				return nil, err
+			}
 		case TypeStruct:
-			// This will be slightly trickier, yet feasible.
-			// We will recurse the call to createObjectFromType, but
-			// we need annotations and type information.
-			metaValue, err := extractAnnotationsFromType(field.Type)
+			// Nested structs are built recursively, using the plan compiled
+			// for the field type.
+			if rawValue == nil {
+				continue
+			}
+			fields, ok := rawValue.([]interface{})
+			if !ok {
+				return nil, schemaMismatchError(t, field.Name, "expected struct, found %T", rawValue)
+			}
+			val, err := createObjectFromPlan(fieldPlan.elem, fields)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
-			val := createObjectFromType(metaValue, field.Type.Elem(), rawValue.([]interface{}))
 			fieldValue.Set(reflect.ValueOf(val))
 		case TypeArray:
-			var curArr []interface{}
-			// Empty arrays will cause rawValue to be nil. Here we can ensure
-			// the array being accessed (and cast) is not nil.
+			newArr, err := buildArray(t, field.Name, field.Type, &fieldPlan.annotation, fieldPlan.elem, rawValue)
+			if err != nil {
+				return nil, err
+			}
+			fieldValue.Set(newArr)
+		case TypeMap:
+			// Empty maps leave fields with their initial value.
 			if rawValue == nil {
-				curArr = []interface{}{}
-			} else {
-				curArr = rawValue.([]interface{})
-			}
-
-			// Custom-type arrays need extra attention here.
-			if fieldMeta.ArrayType == TypeStruct {
-				// At this point, we may have slices of slices, and each group
-				// of slices must be used to instantiate a new object that will
-				// be placed inside the slice.
-				newArr := reflect.MakeSlice(reflect.SliceOf(fieldMeta.ArrayUserType), len(curArr), len(curArr))
-				annotations, err := extractAnnotationsFromType(fieldMeta.ArrayUserType)
-				if err != nil {
-					panic(err)
-				}
-				for i, v := range curArr {
-					// The next line uses Elem() on ArrayUserType, since it will
-					// always be cast to a ptr using PtrTo.
-					val := createObjectFromType(annotations, fieldMeta.ArrayUserType.Elem(), v.([]interface{}))
-					ptr := newArr.Index(i)
-					ptr.Set(reflect.ValueOf(val))
-				}
-
-				fieldValue.Set(newArr)
-			} else {
-				newArr := reflect.MakeSlice(field.Type, len(curArr), len(curArr))
-				for i, v := range curArr {
-					ptr := newArr.Index(i)
-					ptr.Set(reflect.ValueOf(v))
-				}
-
-				fieldValue.Set(newArr)
+				continue
 			}
+			newMap, err := buildMap(t, field.Name, field.Type, &fieldPlan.annotation, fieldPlan.elem, rawValue)
+			if err != nil {
+				return nil, err
+			}
+			fieldValue.Set(newMap)
 		case TypeBlob:
-			fieldValue.Set(rawPointer)
+			if rawValue == nil {
+				continue
+			}
+			if err := setDecodedValue(t, field, fieldValue, rawPointer); err != nil {
+				return nil, err
+			}
+		}
+
+	}
+
+	if plan.unknown >= 0 && len(unknown) > 0 {
+		ptr.Field(plan.unknown).Set(reflect.ValueOf(unknown))
+	}
+
+	return instance.Interface(), nil
+}
+
+// valuesByPosition arranges values decoded from a struct by the position of
+// the fields they belong to, along with the encoded form of values unknown to
+// it. Values of tagged structs are arranged by their field number, leaving
+// missing fields nil, while untagged values are arranged by the order they
+// were received, as positional encoding is still accepted by tagged structs.
+func valuesByPosition(plan *structPlan, values []interface{}) ([]interface{}, LudwiegUnknownFields, error) {
+	if len(values) == 0 {
+		return values, nil, nil
+	}
+	first, ok := values[0].(fieldValue)
+	if !ok {
+		// Values are only wrapped when tagged, or retained, in which case
+		// every value is.
+		for _, v := range values[1:] {
+			if _, ok := v.(fieldValue); ok {
+				return nil, nil, schemaMismatchError(plan.typ, "", "mixed tagged and untagged fields")
+			}
+		}
+		return values, nil, nil
+	}
+	if first.tagged && plan.tags == nil {
+		return nil, nil, schemaMismatchError(plan.typ, "", "unexpected tagged fields")
+	}
+
+	var result []interface{}
+	if first.tagged {
+		result = make([]interface{}, len(plan.fields))
+	} else {
+		result = make([]interface{}, 0, len(plan.fields))
+	}
+	var unknown LudwiegUnknownFields
+	for i, v := range values {
+		field, ok := v.(fieldValue)
+		if !ok || field.tagged != first.tagged {
+			return nil, nil, schemaMismatchError(plan.typ, "", "mixed tagged and untagged fields")
+		}
+		if field.tagged {
+			if j, ok := plan.tags[field.tag]; ok {
+				result[j] = field.value
+				continue
+			}
+		} else if i < len(plan.fields) {
+			result = append(result, field.value)
+			continue
+		}
+		// Unknown values are retained, when the struct declares a field for
+		// them, or discarded otherwise.
+		unknown = append(unknown, field.raw...)
+	}
+	return result, unknown, nil
+}
+
+// buildArray converts decoded array items into a slice of type sliceType.
+// Items of struct arrays are built using the provided plan. Sizes of fixed-size
+// arrays, including inner ones, are checked against annotation, which is nil
+// when unknown.
+func buildArray(owner reflect.Type, field string, sliceType reflect.Type, annotation *LudwiegTypeAnnotation, elem *structPlan, rawValue interface{}) (reflect.Value, error) {
+	if sliceType.Kind() != reflect.Slice {
+		return reflect.Value{}, schemaMismatchError(owner, field, "cannot assign array to %s", sliceType)
+	}
+	// Arrays written with the empty bit set are nil, as opposed to arrays
+	// written without items, which are empty. Both are kept apart, so unset
+	// fields can be told from empty ones.
+	if rawValue == nil {
+		return reflect.Zero(sliceType), nil
+	}
+	curArr, ok := rawValue.([]interface{})
+	if !ok {
+		return reflect.Value{}, schemaMismatchError(owner, field, "expected array, found %T", rawValue)
+	}
+	var itemAnnotation *LudwiegTypeAnnotation
+	if annotation != nil {
+		if err := annotation.checkArraySize(len(curArr)); err != nil {
+			return reflect.Value{}, schemaMismatchError(owner, field, "%s", err)
 		}
+		itemAnnotation = annotation.arrayElem()
+	}
+	newArr := reflect.MakeSlice(sliceType, len(curArr), len(curArr))
 
+	for i, v := range curArr {
+		item, err := buildItem(owner, field, sliceType.Elem(), itemAnnotation, elem, v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		newArr.Index(i).Set(item)
 	}
 
-	return instance.Interface()
+	return newArr, nil
+}
+
+// buildMap converts decoded map entries into a map of type mapType. Values of
+// struct maps are built using the provided plan. annotation is accepted for
+// symmetry with buildArray, as map values are never collections themselves.
+func buildMap(owner reflect.Type, field string, mapType reflect.Type, annotation *LudwiegTypeAnnotation, elem *structPlan, rawValue interface{}) (reflect.Value, error) {
+	entries, ok := rawValue.(map[interface{}]interface{})
+	if !ok {
+		return reflect.Value{}, schemaMismatchError(owner, field, "expected map, found %T", rawValue)
+	}
+	if mapType.Kind() != reflect.Map {
+		return reflect.Value{}, schemaMismatchError(owner, field, "cannot assign map to %s", mapType)
+	}
+	newMap := reflect.MakeMapWithSize(mapType, len(entries))
+
+	for k, v := range entries {
+		key := reflect.ValueOf(k)
+		if !key.Type().ConvertibleTo(mapType.Key()) {
+			return reflect.Value{}, schemaMismatchError(owner, field, "cannot use %s as %s key", key.Type(), mapType.Key())
+		}
+		item, err := buildItem(owner, field, mapType.Elem(), nil, elem, v)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		newMap.SetMapIndex(key.Convert(mapType.Key()), item)
+	}
+
+	return newMap, nil
+}
+
+// buildItem converts a decoded array item or map value, described by
+// annotation when known, into a value of type itemType.
+func buildItem(owner reflect.Type, field string, itemType reflect.Type, annotation *LudwiegTypeAnnotation, elem *structPlan, v interface{}) (reflect.Value, error) {
+	var item reflect.Value
+	isArray := itemType.Kind() == reflect.Slice && itemType.Elem().Kind() != reflect.Uint8
+	if elem == nil && (isArray || itemType.Kind() == reflect.Map) {
+		// Arrays of arrays, or of maps, are built recursively. Plans for
+		// struct items of inner collections are retrieved from the item type
+		// itself.
+		var err error
+		if itemType.Elem().Implements(serializableType) {
+			if elem, err = planFor(itemType.Elem()); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		if isArray {
+			return buildArray(owner, field, itemType, annotation, elem, v)
+		}
+		return buildMap(owner, field, itemType, annotation, elem, v)
+	} else if elem != nil {
+		// Custom-type items need extra attention here. Each group of values
+		// must be used to instantiate a new object that will be placed
+		// inside the collection.
+		fields, ok := v.([]interface{})
+		if !ok {
+			return reflect.Value{}, schemaMismatchError(owner, field, "expected struct item, found %T", v)
+		}
+		val, err := createObjectFromPlan(elem, fields)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		item = reflect.ValueOf(val)
+	} else {
+		if v == nil {
+			return reflect.Value{}, schemaMismatchError(owner, field, "unexpected empty item")
+		}
+		item = reflect.ValueOf(v)
+	}
+	if !item.Type().AssignableTo(itemType) {
+		return reflect.Value{}, schemaMismatchError(owner, field, "cannot assign %s to %s", item.Type(), itemType)
+	}
+	return item, nil
 }
 
-func deserialize(buffer []byte) ([]interface{}, error) {
+// fieldValue retains a value decoded from a struct along with the field
+// number preceding it, for tagged structs, and its encoded form, for structs
+// retaining unknown fields.
+type fieldValue struct {
+	tag    uint16
+	tagged bool
+	raw    []byte
+	value  interface{}
+}
+
+func deserialize(ctx *decodeContext, buffer []byte) ([]interface{}, error) {
 	offset := 0
 	items := []interface{}{}
+	retain := ctx.retainsUnknownFields()
+	var tag *uint16
+	var rawStart int
 
 	for offset < len(buffer) {
 
+		itemOffset := offset
 		metaType := metaTypeFromByte(buffer[offset])
 		incr(&offset)
+		if tag == nil {
+			rawStart = itemOffset
+		}
 
-		if decoder, ok := registeredTypeDecoder[metaType.ManagedType]; ok {
-			if obj, err := decoder(metaType, buffer, &offset); err == nil {
-				items = append(items, obj)
-			} else {
+		if metaType.ManagedType == TypeTag {
+			// Tags are followed by the value of the field they identify.
+			if tag != nil {
+				return items, ctx.error(TypeTag, itemOffset, fmt.Errorf("tag %d is not followed by a value", *tag))
+			}
+			rawTag, err := ctx.read(TypeTag, buffer, &offset, 2)
+			if err != nil {
 				return items, err
 			}
+			t := readUint16(rawTag)
+			tag = &t
+			continue
+		}
+
+		var err error
+		if tag != nil {
+			err = ctx.push(ctx.taggedField(*tag))
 		} else {
-			panic(fmt.Errorf("unknown decoder for type %#v", metaType))
+			err = ctx.push(ctx.field(len(items)))
+		}
+		var obj interface{}
+		decoder, ok := registeredTypeDecoder[metaType.ManagedType]
+		switch {
+		case err != nil:
+		case !ok:
+			err = fmt.Errorf("%w %#v", ErrUnknownType, metaType.Type)
+		default:
+			obj, err = decoder(ctx, metaType, buffer, &offset)
+		}
+		if err != nil {
+			err = ctx.error(metaType.ManagedType, itemOffset, err)
+		}
+		ctx.pop()
+
+		if err != nil {
+			return items, err
+		}
+		if tag != nil || retain {
+			field := fieldValue{value: obj}
+			if tag != nil {
+				field.tag, field.tagged = *tag, true
+				tag = nil
+			}
+			if retain {
+				field.raw = buffer[rawStart:offset]
+			}
+			obj = field
 		}
+		items = append(items, obj)
+	}
+	if tag != nil {
+		return items, ctx.error(TypeTag, offset, io.ErrUnexpectedEOF)
 	}
 	return items, nil
 }
@@ -150,48 +401,3 @@ func incrSize(offset *int, size int) int {
 	*offset = *offset + size
 	return old
 }
-
-func readSize(offset *int, buffer []byte) uint64 {
-
-	b := buffer[incr(offset)]
-
-	switch lengthEncoding(b) {
-	case lengthEncodingEmpty:
-		return uint64(0)
-	case lengthEncodingUint8:
-		buf := buffer[*offset]
-		incr(offset)
-		return uint64(buf)
-	case lengthEncodingUint16:
-		buf := buffer[*offset:2]
-		incrSize(offset, 2)
-		return uint64(readUint16(buf))
-	case lengthEncodingUint32:
-		buf := buffer[*offset:4]
-		incrSize(offset, 4)
-		return uint64(readUint32(buf))
-	case lengthEncodingUint64:
-		buf := buffer[*offset:8]
-		incrSize(offset, 8)
-		return readUint64(buf)
-	default:
-		panic(fmt.Errorf("unknown size prefix %#v", b))
-	}
-}
-
-func extractAnnotationsFromType(t reflect.Type) ([]LudwiegTypeAnnotation, error) {
-	serializable := reflect.TypeOf((*Serializable)(nil)).Elem()
-	if !t.Implements(serializable) {
-		return nil, fmt.Errorf("type %s does not implement Serializable", t.String())
-	}
-
-	if t.Kind() != reflect.Struct {
-		t = t.Elem()
-	}
-
-	structIndirect := reflect.Indirect(reflect.New(t))
-	return structIndirect.
-		MethodByName("LudwiegMeta").
-		Call([]reflect.Value{})[0].
-		Interface().([]LudwiegTypeAnnotation), nil
-}
@@ -5,51 +5,54 @@ import (
 	"reflect"
 )
 
-type registeredPackage struct {
-	nativeType reflect.Type
-	id         byte
-	meta       []LudwiegTypeAnnotation
-}
-
 // RegisterPackages is responsible for registering a known package under a
 // message ID. It is then used afterwards to decode incoming messages. This
 // method is called automatically, when generating sources using the "ludco"
-// command line utility.
+// command line utility. Packages are registered on the default Registry, used
+// by Deserializers that were not provided a Registry of their own.
 func RegisterPackages(pkgs ...SerializablePackage) {
-	for _, pkg := range pkgs {
-		id := pkg.LudwiegID()
-		if _, ok := registeredPackages[id]; ok {
-			panic(fmt.Errorf("illegal attempt to register two packages with same id: %#v", id))
-		}
+	if err := defaultRegistry.Register(pkgs...); err != nil {
+		panic(err)
+	}
+}
 
-		registeredPackages[id] = registeredPackage{
-			id:         id,
-			meta:       pkg.LudwiegMeta(),
-			nativeType: reflect.TypeOf(pkg),
-		}
+// RegisterAnyType registers a struct under the provided ID on the default
+// Registry, allowing it to be retained by Any values. Panics in case the ID or
+// struct were already registered.
+func RegisterAnyType(id uint16, s Serializable) {
+	if err := defaultRegistry.RegisterAnyType(id, s); err != nil {
+		panic(err)
 	}
 }
 
-type typeDecoderFunc func(t metaProtocolByte, b []byte, offset *int) (interface{}, error)
+type typeDecoderFunc func(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error)
 
-var registeredPackages = map[byte]registeredPackage{}
 var registeredTypeDecoder map[ProtocolType]typeDecoderFunc
 
 func init() {
 	registeredTypeDecoder = map[ProtocolType]typeDecoderFunc{
-		TypeUnknown: decodeUnknown,
-		TypeUint8:   decodeUint8,
-		TypeUint32:  decodeUint32,
-		TypeUint64:  decodeUint64,
-		TypeDouble:  decodeDouble,
-		TypeString:  decodeString,
-		TypeBlob:    decodeBlob,
-		TypeBool:    decodeBool,
-		TypeUUID:    decodeUUID,
-		TypeAny:     decodeAny,
-		TypeArray:   decodeArray,
-		TypeStruct:  decodeStruct,
-		TypeDynInt:  decodeDynInt,
+		TypeUnknown:   decodeUnknown,
+		TypeUint8:     decodeUint8,
+		TypeUint32:    decodeUint32,
+		TypeUint64:    decodeUint64,
+		TypeDouble:    decodeDouble,
+		TypeString:    decodeString,
+		TypeBlob:      decodeBlob,
+		TypeBool:      decodeBool,
+		TypeUUID:      decodeUUID,
+		TypeAny:       decodeAny,
+		TypeArray:     decodeArray,
+		TypeStruct:    decodeStruct,
+		TypeDynInt:    decodeDynInt,
+		TypeUint16:    decodeUint16,
+		TypeInt8:      decodeInt8,
+		TypeInt16:     decodeInt16,
+		TypeInt32:     decodeInt32,
+		TypeInt64:     decodeInt64,
+		TypeFloat:     decodeFloat,
+		TypeTimestamp: decodeTimestamp,
+		TypeDuration:  decodeDuration,
+		TypeMap:       decodeMap,
 	}
 }
 
@@ -67,26 +70,99 @@ const (
 	statusPayload
 )
 
+// initialPayloadCapacity is the largest buffer allocated for a payload before
+// its bytes are received. Larger payloads grow their buffers as bytes arrive,
+// so declared sizes alone cannot cause large allocations.
+const initialPayloadCapacity = 4 << 10
+
 // Deserializer is responsible for keeping track of a basic FSM used to parse
 // metadata about a received message, returning a DeserializationCandidate when
 // all bytes are received.
 type Deserializer struct {
-	state     deserializerStatus
-	msgMeta   *MessageMetadata
-	tmpBuffer []byte
-	lastError error
-	readBytes uint64
+	opts           DeserializerOptions
+	state          deserializerStatus
+	msgMeta        *MessageMetadata
+	tmpBuffer      []byte
+	payloadSize    uint64
+	lastError      error
+	readBytes      uint64
+	resyncing      bool
+	discardedBytes uint64
+	droppedFrames  uint64
+}
+
+// NewDeserializer returns a new Deserializer enforcing the provided options.
+// A zero-valued Deserializer is also ready to use, assuming default options.
+func NewDeserializer(opts DeserializerOptions) *Deserializer {
+	return &Deserializer{opts: opts}
 }
 
 func (d *Deserializer) reset() {
 	d.state = statusPrelude
 	d.msgMeta = nil
-	d.lastError = nil
+	d.tmpBuffer = nil
+	d.payloadSize = 0
 	d.readBytes = 0
 }
 
+// report records err as the last error observed by the FSM, and provides it
+// to the OnError callback, when set.
+func (d *Deserializer) report(err error) {
+	d.lastError = err
+	if d.opts.OnError != nil {
+		d.opts.OnError(err)
+	}
+}
+
+// fail records err as the reason the current message was dropped, and resets
+// the FSM so it can synchronise with the next message. Remaining bytes of the
+// dropped message are discarded without being reported again.
+func (d *Deserializer) fail(err error) {
+	d.droppedFrames++
+	d.discardedBytes += d.readBytes
+	d.report(err)
+	d.reset()
+	d.resyncing = true
+}
+
+// discard drops n bytes that do not belong to any message. A single
+// ErrBadMagic is reported for each run of garbage, until the FSM synchronises
+// with a message again.
+func (d *Deserializer) discard(n uint64) {
+	d.discardedBytes += n
+	if !d.resyncing {
+		d.resyncing = true
+		d.report(ErrBadMagic)
+	}
+}
+
+// LastError returns the reason of the last reset performed by the FSM, or nil
+// in case all received bytes were part of valid messages.
+func (d *Deserializer) LastError() error {
+	return d.lastError
+}
+
+// DiscardedBytes returns how many received bytes were discarded, either for not
+// being part of a message, or for belonging to a dropped one.
+func (d *Deserializer) DiscardedBytes() uint64 {
+	return d.discardedBytes
+}
+
+// DroppedFrames returns how many messages were dropped after their magic bytes
+// were received, due to invalid or unsupported headers.
+func (d *Deserializer) DroppedFrames() uint64 {
+	return d.droppedFrames
+}
+
+// inProgress indicates whether the FSM has consumed bytes belonging to a
+// message that was not yet completely received.
+func (d *Deserializer) inProgress() bool {
+	return d.state != statusPrelude || d.readBytes > 0
+}
+
 // Feed provides a single byte to the FSM. If an invalid or unexpected value is
-// received, the FSM is automatically reseted.
+// received, the FSM is automatically reseted and the reason is reported
+// through LastError and the OnError callback.
 func (d *Deserializer) Feed(b byte) *DeserializationCandidate {
 	switch d.state {
 	case statusPrelude:
@@ -96,11 +172,25 @@ func (d *Deserializer) Feed(b byte) *DeserializationCandidate {
 			if d.readBytes == uint64(len(magicBytes)) {
 				d.state = statusProtocolVersion
 				d.msgMeta = &MessageMetadata{}
+				d.resyncing = false
 			}
 		} else {
+			discarded := d.readBytes
 			d.reset()
+			// The unexpected byte may be starting a new message by itself.
+			if b == magicBytes[0] {
+				d.readBytes++
+			} else {
+				discarded++
+			}
+			d.discard(discarded)
 		}
 	case statusProtocolVersion:
+		if b != protocolVersion {
+			d.readBytes++
+			d.fail(fmt.Errorf("%w: %#x", ErrUnsupportedVersion, b))
+			return nil
+		}
 		d.msgMeta.ProtocolVersion = b
 		d.readBytes++
 		d.state = statusMessageID
@@ -114,12 +204,13 @@ func (d *Deserializer) Feed(b byte) *DeserializationCandidate {
 		d.state = statusPackageSizePrelude
 	case statusPackageSizePrelude:
 		if b > byte(lengthEncodingUint64) {
-			d.reset()
+			d.readBytes++
+			d.fail(fmt.Errorf("%w: %#x", ErrBadLengthEncoding, b))
+			return nil
 		}
 		switch lengthEncoding(b) {
 		case lengthEncodingEmpty:
-			defer d.reset()
-			return d.candidate()
+			return d.complete()
 		case lengthEncodingUint8:
 			d.tmpBuffer = make([]byte, 0, 1)
 		case lengthEncodingUint16:
@@ -135,34 +226,84 @@ func (d *Deserializer) Feed(b byte) *DeserializationCandidate {
 		d.tmpBuffer = append(d.tmpBuffer, b)
 		d.readBytes++
 		if len(d.tmpBuffer) == cap(d.tmpBuffer) {
-			var newBuf []byte
+			var size uint64
 			switch cap(d.tmpBuffer) {
 			case 1:
-				newBuf = make([]byte, 0, d.tmpBuffer[0])
+				size = uint64(d.tmpBuffer[0])
 			case 2:
-				newBuf = make([]byte, 0, readUint16(d.tmpBuffer))
+				size = uint64(readUint16(d.tmpBuffer))
 			case 4:
-				newBuf = make([]byte, 0, readUint32(d.tmpBuffer))
+				size = uint64(readUint32(d.tmpBuffer))
 			case 8:
-				newBuf = make([]byte, 0, readUint64(d.tmpBuffer))
+				size = readUint64(d.tmpBuffer)
+			}
+			if err := checkLimit("payload size", size, d.opts.withDefaults().MaxPayloadSize); err != nil {
+				d.fail(err)
+				return nil
+			}
+			capacity := size
+			if capacity > initialPayloadCapacity {
+				capacity = initialPayloadCapacity
 			}
-			d.tmpBuffer = newBuf
+			d.tmpBuffer = make([]byte, 0, capacity)
+			d.payloadSize = size
 			d.state = statusPayload
+			if size == 0 {
+				return d.complete()
+			}
 		}
 	case statusPayload:
 		d.tmpBuffer = append(d.tmpBuffer, b)
 		d.readBytes++
-		if len(d.tmpBuffer) == cap(d.tmpBuffer) {
-			defer d.reset()
-			return d.candidate()
+		if uint64(len(d.tmpBuffer)) == d.payloadSize {
+			return d.complete()
 		}
 	}
 	return nil
 }
 
+// FeedBytes provides a slice of bytes to the FSM, returning a candidate for
+// each message completed within it. Payloads are copied in whole chunks, and
+// incomplete messages are retained across calls. Every byte of p is processed,
+// being either part of a returned candidate, retained as part of a pending
+// message, or discarded as garbage, hence consumed is always len(p).
+func (d *Deserializer) FeedBytes(p []byte) (candidates []*DeserializationCandidate, consumed int) {
+	for i := 0; i < len(p); {
+		if d.state == statusPayload {
+			n := len(p) - i
+			if missing := d.payloadSize - uint64(len(d.tmpBuffer)); uint64(n) > missing {
+				n = int(missing)
+			}
+			d.tmpBuffer = append(d.tmpBuffer, p[i:i+n]...)
+			d.readBytes += uint64(n)
+			i += n
+			if uint64(len(d.tmpBuffer)) == d.payloadSize {
+				candidates = append(candidates, d.complete())
+			}
+			continue
+		}
+
+		c := d.Feed(p[i])
+		i++
+		if c != nil {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates, len(p)
+}
+
+// complete returns a candidate for the message currently being read and
+// resets the FSM.
+func (d *Deserializer) complete() *DeserializationCandidate {
+	c := d.candidate()
+	d.reset()
+	return c
+}
+
 func (d *Deserializer) candidate() *DeserializationCandidate {
 	return &DeserializationCandidate{
 		buffer:      d.tmpBuffer,
+		opts:        d.opts,
 		MessageMeta: d.msgMeta,
 	}
 }
@@ -171,9 +312,38 @@ func (d *Deserializer) candidate() *DeserializationCandidate {
 // SerializeNonMessage) to be converted back to a known type.
 // Returns a pointer to the object with the provided type, or an error.
 func DeserializeNonMessage(data []byte, into Serializable) (interface{}, error) {
-	fields, err := deserialize(data)
+	t := reflect.TypeOf(into)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w: no value found for %s", ErrSchemaMismatch, t.Name())
+	}
+
+	// Non-messages hold a single struct value, which is decoded directly so
+	// its fields can be identified by the decoding context.
+	meta := metaTypeFromByte(data[0])
+	if meta.ManagedType != TypeStruct {
+		return nil, fmt.Errorf("%w: expected struct for %s, found %s", ErrSchemaMismatch, t.Name(), meta.ManagedType)
+	}
+	if meta.Empty {
+		return nil, fmt.Errorf("%w: no value found for %s", ErrSchemaMismatch, t.Name())
+	}
+	plan, err := planFor(t)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := newDecodeContext(DeserializerOptions{}, t)
+	offset := 1
+	size, err := ctx.readSize(TypeStruct, data, &offset)
+	if err != nil {
+		return nil, err
+	}
+	start := offset
+	buf, err := ctx.read(TypeStruct, data, &offset, size)
 	if err != nil {
 		return nil, err
 	}
-	return createObjectFromType(into.LudwiegMeta(), reflect.TypeOf(into), fields[0].([]interface{})), nil
+	return unmarshalStruct(ctx, plan, buf, start)
 }
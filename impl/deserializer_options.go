@@ -0,0 +1,80 @@
+package impl
+
+import (
+	"fmt"
+)
+
+const (
+	// DefaultMaxPayloadSize is the payload size limit used when
+	// DeserializerOptions.MaxPayloadSize is not set.
+	DefaultMaxPayloadSize = 64 << 20
+
+	// DefaultMaxArrayItems is the array length limit used when
+	// DeserializerOptions.MaxArrayItems is not set.
+	DefaultMaxArrayItems = 1 << 20
+
+	// DefaultMaxStringLen is the string length limit used when
+	// DeserializerOptions.MaxStringLen is not set.
+	DefaultMaxStringLen = 16 << 20
+
+	// DefaultMaxDepth is the nesting depth limit used when
+	// DeserializerOptions.MaxDepth is not set.
+	DefaultMaxDepth = 100
+)
+
+// DeserializerOptions retains limits enforced when receiving and decoding
+// messages, preventing hostile length prefixes from causing huge allocations.
+// Fields left as zero assume their respective default values.
+type DeserializerOptions struct {
+	// MaxPayloadSize is the largest payload, in bytes, a message may declare.
+	// It also bounds the size of blob values.
+	MaxPayloadSize uint64
+
+	// MaxArrayItems is the largest amount of items an array may declare.
+	MaxArrayItems uint64
+
+	// MaxStringLen is the largest length, in bytes, of a string value.
+	MaxStringLen uint64
+
+	// MaxDepth is the deepest level values may be nested at, counting each
+	// struct field, array item and map value.
+	MaxDepth uint64
+
+	// Registry retains packages that may be deserialized. When not set, the
+	// default Registry, used by RegisterPackages, is assumed.
+	Registry *Registry
+
+	// OnError, when set, is invoked whenever the Deserializer discards bytes
+	// or drops a message, with the reason it had to be reset.
+	OnError func(err error)
+}
+
+func (o DeserializerOptions) withDefaults() DeserializerOptions {
+	if o.MaxPayloadSize == 0 {
+		o.MaxPayloadSize = DefaultMaxPayloadSize
+	}
+	if o.MaxArrayItems == 0 {
+		o.MaxArrayItems = DefaultMaxArrayItems
+	}
+	if o.MaxStringLen == 0 {
+		o.MaxStringLen = DefaultMaxStringLen
+	}
+	if o.MaxDepth == 0 {
+		o.MaxDepth = DefaultMaxDepth
+	}
+	return o
+}
+
+func (o DeserializerOptions) registry() *Registry {
+	if o.Registry == nil {
+		return defaultRegistry
+	}
+	return o.Registry
+}
+
+func checkLimit(what string, size, limit uint64) error {
+	if size > limit {
+		return fmt.Errorf("%w: %s of %d exceeds limit of %d", ErrFrameTooLarge, what, size, limit)
+	}
+	return nil
+}
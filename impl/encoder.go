@@ -0,0 +1,90 @@
+package impl
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// maxHeaderSize is the largest amount of bytes written before a message
+// payload: magic bytes, message metadata, and an uint64-encoded size.
+const maxHeaderSize = 3 + 3 + 1 + 8
+
+// maxPooledBufferSize prevents unusually large payload buffers from being
+// retained by the pool after use.
+const maxPooledBufferSize = 1 << 20
+
+var encoderBufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+// Encoder writes messages to an output stream. Payloads are serialised into
+// pooled buffers and written directly after the message header, avoiding
+// intermediate copies.
+type Encoder struct {
+	w      io.Writer
+	opts   EncoderOptions
+	header bytes.Buffer
+}
+
+// EncoderOptions retains settings used when serialising messages.
+type EncoderOptions struct {
+	// Registry retains structs that may be retained by Any values. When not
+	// set, the default Registry, used by RegisterAnyType, is assumed.
+	Registry *Registry
+}
+
+// NewEncoder returns a new Encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return NewEncoderWithOptions(w, EncoderOptions{})
+}
+
+// NewEncoderWithOptions returns a new Encoder that writes to w, using the
+// provided options.
+func NewEncoderWithOptions(w io.Writer, opts EncoderOptions) *Encoder {
+	e := &Encoder{w: w, opts: opts}
+	e.header.Grow(maxHeaderSize)
+	return e
+}
+
+// Encode serializes a given SerializablePackage under the provided messageID
+// and writes it to the underlying stream.
+func (e *Encoder) Encode(p SerializablePackage, messageID byte) error {
+	payload := encoderBufferPool.Get().(*bytes.Buffer)
+	defer func() {
+		if payload.Cap() <= maxPooledBufferSize {
+			payload.Reset()
+			encoderBufferPool.Put(payload)
+		}
+	}()
+
+	value := reflect.ValueOf(p)
+	err := serializeStruct(&serializationCandidate{
+		isRoot:    true,
+		writeType: false,
+		value:     &value,
+		registry:  e.opts.Registry,
+	}, payload)
+	if err != nil {
+		return err
+	}
+
+	e.header.Reset()
+	e.header.Write(magicBytes)
+	meta := MessageMetadata{
+		ProtocolVersion: protocolVersion,
+		MessageID:       messageID,
+		PackageType:     p.LudwiegID(),
+	}
+	meta.writeTo(&e.header)
+	writeSize(uint64(payload.Len()), &e.header)
+
+	if _, err := e.w.Write(e.header.Bytes()); err != nil {
+		return err
+	}
+	_, err = e.w.Write(payload.Bytes())
+	return err
+}
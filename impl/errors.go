@@ -0,0 +1,78 @@
+package impl
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrFrameTooLarge indicates a message or one of its values declared a
+	// length larger than the limits allowed by DeserializerOptions.
+	ErrFrameTooLarge = errors.New("frame too large")
+
+	// ErrBadMagic indicates bytes not belonging to any message were received
+	// and discarded while looking for the next message.
+	ErrBadMagic = errors.New("bad magic bytes")
+
+	// ErrBadLengthEncoding indicates a message declared its payload size using
+	// an unknown length encoding.
+	ErrBadLengthEncoding = errors.New("bad length encoding")
+
+	// ErrUnsupportedVersion indicates a message was produced using an
+	// unsupported protocol version.
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+
+	// ErrUnknownPackage indicates a message carries a package type that was
+	// not registered.
+	ErrUnknownPackage = errors.New("cannot deserialize unknown package")
+
+	// ErrUnknownType indicates a value carries a type no decoder is able to
+	// handle.
+	ErrUnknownType = errors.New("unknown decoder for type")
+
+	// ErrBadLengthPrefix indicates a value declared its length using an
+	// unknown length encoding.
+	ErrBadLengthPrefix = errors.New("unknown size prefix")
+
+	// ErrSchemaMismatch indicates decoded values do not match the annotations
+	// or fields of the structure they were decoded into.
+	ErrSchemaMismatch = errors.New("schema mismatch")
+
+	// ErrMaxDepth indicates a message nests values deeper than allowed by
+	// DeserializerOptions.
+	ErrMaxDepth = errors.New("maximum nesting depth exceeded")
+
+	// ErrDynIntOverflow indicates a DynInt value cannot be represented by the
+	// type requested through one of its accessors.
+	ErrDynIntOverflow = errors.New("dynint overflow")
+)
+
+// DecodeError describes a failure to decode a value, indicating where within
+// the payload it happened.
+type DecodeError struct {
+	// Offset is the position of the failure, relative to the beginning of
+	// the payload.
+	Offset int
+
+	// Type is the type of the value being decoded.
+	Type ProtocolType
+
+	// Path is the path of the field being decoded, such as "FieldI.FieldK".
+	// Array items are identified by their index, such as "FieldZ[1]".
+	Path string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "<root>"
+	}
+	return fmt.Sprintf("cannot decode %s field %s at offset %d: %s", e.Type, path, e.Offset, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
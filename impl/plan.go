@@ -0,0 +1,198 @@
+package impl
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// structPlan retains information about a Serializable struct type, compiled
+// once and reused every time a value of that type is serialised or decoded,
+// avoiding repeated calls to LudwiegMeta and walks over its reflected fields.
+type structPlan struct {
+	// typ is the struct type described by this plan. It is never a pointer.
+	typ reflect.Type
+
+	// fields retains a plan for each annotated field, in declaration order.
+	fields []fieldPlan
+
+	// tags maps field numbers of tagged structs to their position in fields.
+	// It is nil for structs encoded positionally.
+	tags map[uint16]int
+
+	// unknown is the index of the LudwiegUnknownFields field retaining
+	// fields unknown to the struct, or -1 when it declares none.
+	unknown int
+}
+
+// fieldPlan retains information about a single field of a struct.
+type fieldPlan struct {
+	index      int
+	annotation LudwiegTypeAnnotation
+	meta       metaProtocolByte
+
+	// elem retains the plan for the struct held by this field, by items of an
+	// array of structs, or by values of a map of structs. It is nil for other
+	// types.
+	elem *structPlan
+}
+
+var (
+	planCache sync.Map // map[reflect.Type]*structPlan
+	planLock  sync.Mutex
+)
+
+// planFor returns the cached plan for the provided struct type (or pointer to
+// struct type), compiling it on first use.
+func planFor(t reflect.Type) (*structPlan, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if p, ok := planCache.Load(t); ok {
+		return p.(*structPlan), nil
+	}
+
+	planLock.Lock()
+	defer planLock.Unlock()
+
+	// Plans referring to each other (or to themselves) are only published
+	// after all of them were compiled successfully.
+	compiling := map[reflect.Type]*structPlan{}
+	p, err := compilePlan(t, compiling)
+	if err != nil {
+		return nil, err
+	}
+	for t, p := range compiling {
+		planCache.Store(t, p)
+	}
+	return p, nil
+}
+
+func compilePlan(t reflect.Type, compiling map[reflect.Type]*structPlan) (*structPlan, error) {
+	if p, ok := planCache.Load(t); ok {
+		return p.(*structPlan), nil
+	}
+	if p, ok := compiling[t]; ok {
+		return p, nil
+	}
+
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%w: %s is not a struct", ErrSchemaMismatch, t)
+	}
+	annotations, err := extractAnnotationsFromType(t)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrSchemaMismatch, err)
+	}
+	indexes, unknown, err := annotatedFields(t)
+	if err != nil {
+		return nil, schemaMismatchError(t, "", "%s", err)
+	}
+	if len(annotations) != len(indexes) {
+		return nil, schemaMismatchError(t, "", "%d annotations for %d fields", len(annotations), len(indexes))
+	}
+
+	tags, err := annotationTags(annotations)
+	if err != nil {
+		return nil, schemaMismatchError(t, "", "%s", err)
+	}
+
+	p := &structPlan{
+		typ:     t,
+		fields:  make([]fieldPlan, len(annotations)),
+		tags:    tags,
+		unknown: unknown,
+	}
+	compiling[t] = p
+
+	for i, annotation := range annotations {
+		field := t.Field(indexes[i])
+		f := fieldPlan{
+			index:      indexes[i],
+			annotation: annotation,
+			meta:       *annotation.metaProtocolByte(),
+		}
+		if annotation.Type == TypeArray {
+			if _, err := annotation.fixedArraySize(); err != nil {
+				return nil, schemaMismatchError(t, field.Name, "%s", err)
+			}
+		}
+
+		var elemType reflect.Type
+		switch {
+		case annotation.Type == TypeStruct:
+			elemType = field.Type
+		case annotation.Type == TypeArray && annotation.arrayElem().Type == TypeStruct:
+			elemType = annotation.ArrayUserType
+			if elemType == nil && field.Type.Kind() == reflect.Slice {
+				elemType = field.Type.Elem()
+			}
+		case annotation.Type == TypeMap && annotation.MapValueType == TypeStruct:
+			elemType = annotation.MapValueUserType
+		}
+		if elemType != nil {
+			if elemType.Kind() != reflect.Ptr {
+				return nil, schemaMismatchError(t, field.Name, "expected pointer to struct, found %s", elemType)
+			}
+			if f.elem, err = compilePlan(elemType.Elem(), compiling); err != nil {
+				return nil, err
+			}
+		}
+
+		p.fields[i] = f
+	}
+
+	return p, nil
+}
+
+var unknownFieldsType = reflect.TypeOf(LudwiegUnknownFields(nil))
+
+// annotatedFields returns indexes of fields of t described by annotations,
+// which are all of them but the one retaining unknown fields, along with the
+// index of the latter, or -1 when t declares none.
+func annotatedFields(t reflect.Type) (indexes []int, unknown int, err error) {
+	unknown = -1
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type != unknownFieldsType {
+			indexes = append(indexes, i)
+			continue
+		}
+		if unknown >= 0 {
+			return nil, -1, fmt.Errorf("both %s and %s retain unknown fields", t.Field(unknown).Name, t.Field(i).Name)
+		}
+		unknown = i
+	}
+	return indexes, unknown, nil
+}
+
+// annotationTags maps field numbers declared by the provided annotations to
+// their position, returning nil when no annotation declares one.
+func annotationTags(annotations []LudwiegTypeAnnotation) (map[uint16]int, error) {
+	var tags map[uint16]int
+	for i, annotation := range annotations {
+		if annotation.Tag == 0 {
+			continue
+		}
+		if tags == nil {
+			tags = make(map[uint16]int, len(annotations))
+		}
+		if j, ok := tags[annotation.Tag]; ok {
+			return nil, fmt.Errorf("fields %d and %d share tag %d", j, i, annotation.Tag)
+		}
+		tags[annotation.Tag] = i
+	}
+	if tags != nil && len(tags) != len(annotations) {
+		return nil, fmt.Errorf("%d of %d fields declare no tag", len(annotations)-len(tags), len(annotations))
+	}
+	return tags, nil
+}
+
+func extractAnnotationsFromType(t reflect.Type) ([]LudwiegTypeAnnotation, error) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	serializable, ok := reflect.New(t).Interface().(Serializable)
+	if !ok {
+		return nil, fmt.Errorf("type %s does not implement Serializable", t.String())
+	}
+	return serializable.LudwiegMeta(), nil
+}
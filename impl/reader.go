@@ -0,0 +1,339 @@
+package impl
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// LudwiegUnmarshaler may be implemented by generated structures to decode
+// their own fields without relying on reflection. Fields must be read in the
+// same order they are annotated by LudwiegMeta. Tagged structs are always
+// decoded through reflection, as their fields may arrive in any order.
+type LudwiegUnmarshaler interface {
+	UnmarshalLudwieg(r *Reader) error
+}
+
+var serializableType = reflect.TypeOf((*Serializable)(nil)).Elem()
+
+// Reader provides primitives used by LudwiegUnmarshaler implementations to
+// read struct fields. Each method reads a single field, returning nil values
+// for fields absent from the payload, such as fields introduced by newer
+// versions of a structure.
+type Reader struct {
+	ctx    *decodeContext
+	buf    []byte
+	base   int
+	offset int
+	field  int
+}
+
+func newReader(ctx *decodeContext, buf []byte, base int) *Reader {
+	return &Reader{ctx: ctx, buf: buf, base: base}
+}
+
+// Remaining indicates whether there are fields left to be read.
+func (r *Reader) Remaining() bool {
+	return r.offset < len(r.buf)
+}
+
+// next reads the next field, which is expected to be of type t, through the
+// provided function.
+func (r *Reader) next(t ProtocolType, read func(meta metaProtocolByte) (interface{}, error)) (interface{}, error) {
+	if !r.Remaining() {
+		return nil, nil
+	}
+
+	base := r.ctx.base
+	r.ctx.base = r.base
+	err := r.ctx.push(r.ctx.field(r.field))
+	defer func() {
+		r.ctx.pop()
+		r.ctx.base = base
+	}()
+	r.field++
+	if err != nil {
+		return nil, r.ctx.error(t, r.offset, err)
+	}
+
+	start := r.offset
+	meta := metaTypeFromByte(r.buf[incr(&r.offset)])
+	if meta.ManagedType != t {
+		return nil, r.ctx.error(t, start, fmt.Errorf("%w: expected %s, found %s", ErrSchemaMismatch, t, meta.ManagedType))
+	}
+
+	v, err := read(meta)
+	if err != nil {
+		return nil, r.ctx.error(t, start, err)
+	}
+	return v, nil
+}
+
+func (r *Reader) decode(t ProtocolType) (interface{}, error) {
+	return r.next(t, func(meta metaProtocolByte) (interface{}, error) {
+		return registeredTypeDecoder[t](r.ctx, meta, r.buf, &r.offset)
+	})
+}
+
+// ReadUint8 reads an Uint8 field
+func (r *Reader) ReadUint8() (*LudwiegUint8, error) {
+	v, err := r.decode(TypeUint8)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegUint8), nil
+}
+
+// ReadUint32 reads an Uint32 field
+func (r *Reader) ReadUint32() (*LudwiegUint32, error) {
+	v, err := r.decode(TypeUint32)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegUint32), nil
+}
+
+// ReadUint64 reads an Uint64 field
+func (r *Reader) ReadUint64() (*LudwiegUint64, error) {
+	v, err := r.decode(TypeUint64)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegUint64), nil
+}
+
+// ReadUint16 reads an Uint16 field
+func (r *Reader) ReadUint16() (*LudwiegUint16, error) {
+	v, err := r.decode(TypeUint16)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegUint16), nil
+}
+
+// ReadInt8 reads an Int8 field
+func (r *Reader) ReadInt8() (*LudwiegInt8, error) {
+	v, err := r.decode(TypeInt8)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegInt8), nil
+}
+
+// ReadInt16 reads an Int16 field
+func (r *Reader) ReadInt16() (*LudwiegInt16, error) {
+	v, err := r.decode(TypeInt16)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegInt16), nil
+}
+
+// ReadInt32 reads an Int32 field
+func (r *Reader) ReadInt32() (*LudwiegInt32, error) {
+	v, err := r.decode(TypeInt32)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegInt32), nil
+}
+
+// ReadInt64 reads an Int64 field
+func (r *Reader) ReadInt64() (*LudwiegInt64, error) {
+	v, err := r.decode(TypeInt64)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegInt64), nil
+}
+
+// ReadFloat reads a Float field
+func (r *Reader) ReadFloat() (*LudwiegFloat, error) {
+	v, err := r.decode(TypeFloat)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegFloat), nil
+}
+
+// ReadDouble reads a Double field
+func (r *Reader) ReadDouble() (*LudwiegDouble, error) {
+	v, err := r.decode(TypeDouble)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegDouble), nil
+}
+
+// ReadString reads a String field
+func (r *Reader) ReadString() (*LudwiegString, error) {
+	v, err := r.decode(TypeString)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegString), nil
+}
+
+// ReadBlob reads a Blob field
+func (r *Reader) ReadBlob() ([]byte, error) {
+	v, err := r.decode(TypeBlob)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// ReadBool reads a Bool field
+func (r *Reader) ReadBool() (*LudwiegBool, error) {
+	v, err := r.decode(TypeBool)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegBool), nil
+}
+
+// ReadUUID reads an UUID field
+func (r *Reader) ReadUUID() (*LudwiegUUID, error) {
+	v, err := r.decode(TypeUUID)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegUUID), nil
+}
+
+// ReadTimestamp reads a Timestamp field
+func (r *Reader) ReadTimestamp() (*LudwiegTimestamp, error) {
+	v, err := r.decode(TypeTimestamp)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegTimestamp), nil
+}
+
+// ReadDuration reads a Duration field
+func (r *Reader) ReadDuration() (*LudwiegDuration, error) {
+	v, err := r.decode(TypeDuration)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegDuration), nil
+}
+
+// ReadAny reads an Any field
+func (r *Reader) ReadAny() (*LudwiegAny, error) {
+	v, err := r.decode(TypeAny)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegAny), nil
+}
+
+// ReadDynInt reads a DynInt field
+func (r *Reader) ReadDynInt() (*LudwiegDynInt, error) {
+	v, err := r.decode(TypeDynInt)
+	if v == nil || err != nil {
+		return nil, err
+	}
+	return v.(*LudwiegDynInt), nil
+}
+
+// ReadStruct reads a Struct field using the provided structure as a
+// template. Returns a pointer to a new object with the same type, or nil in
+// case the field is empty.
+func (r *Reader) ReadStruct(into Serializable) (interface{}, error) {
+	t := reflect.TypeOf(into)
+	return r.next(TypeStruct, func(meta metaProtocolByte) (interface{}, error) {
+		if meta.Empty {
+			return nil, nil
+		}
+		size, err := r.ctx.readSize(TypeStruct, r.buf, &r.offset)
+		if err != nil {
+			return nil, err
+		}
+		start := r.offset
+		buf, err := r.ctx.read(TypeStruct, r.buf, &r.offset, size)
+		if err != nil {
+			return nil, err
+		}
+		plan, err := planFor(t)
+		if err != nil {
+			return nil, err
+		}
+		return unmarshalStruct(r.ctx, plan, buf, r.ctx.base+start)
+	})
+}
+
+// ReadArray reads an Array field into the slice pointed by into.
+func (r *Reader) ReadArray(into interface{}) error {
+	return r.readCollection(into, reflect.Slice, TypeArray, decodeArray, buildArray)
+}
+
+// ReadMap reads a Map field into the map pointed by into.
+func (r *Reader) ReadMap(into interface{}) error {
+	return r.readCollection(into, reflect.Map, TypeMap, decodeMap, buildMap)
+}
+
+type collectionBuilder func(owner reflect.Type, field string, typ reflect.Type, annotation *LudwiegTypeAnnotation, elem *structPlan, rawValue interface{}) (reflect.Value, error)
+
+// readCollection reads an array or map field into the value pointed by into,
+// which must be of the provided kind.
+func (r *Reader) readCollection(into interface{}, kind reflect.Kind, t ProtocolType, decode typeDecoderFunc, build collectionBuilder) error {
+	ptr := reflect.ValueOf(into)
+	if ptr.Kind() != reflect.Ptr || ptr.Elem().Kind() != kind {
+		return fmt.Errorf("cannot read %s into %T", t, into)
+	}
+	typ := ptr.Elem().Type()
+
+	var elem *structPlan
+	if item := typ.Elem(); item.Implements(serializableType) {
+		var err error
+		if elem, err = planFor(item); err != nil {
+			return err
+		}
+	}
+
+	v, err := r.next(t, func(meta metaProtocolByte) (interface{}, error) {
+		raw, err := decode(r.ctx, meta, r.buf, &r.offset)
+		if err != nil || raw == nil {
+			return nil, err
+		}
+		collection, err := build(nil, r.ctx.pathString(), typ, nil, elem, raw)
+		if err != nil {
+			return nil, err
+		}
+		return collection.Interface(), nil
+	})
+	if err != nil {
+		return err
+	}
+	if v == nil {
+		ptr.Elem().Set(reflect.Zero(typ))
+	} else {
+		ptr.Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+// unmarshalStruct decodes the fields contained in buf into a new object
+// described by plan, using its LudwiegUnmarshaler implementation when
+// available. base indicates the offset of buf within the payload.
+func unmarshalStruct(ctx *decodeContext, plan *structPlan, buf []byte, base int) (interface{}, error) {
+	instance := reflect.New(plan.typ)
+	if u, ok := instance.Interface().(LudwiegUnmarshaler); ok && plan.tags == nil {
+		r := newReader(ctx, buf, base)
+		if err := u.UnmarshalLudwieg(r); err != nil {
+			return nil, err
+		}
+		if plan.unknown >= 0 && r.Remaining() {
+			// Fields left unread are unknown to the struct.
+			unknown := append(LudwiegUnknownFields(nil), buf[r.offset:]...)
+			instance.Elem().Field(plan.unknown).Set(reflect.ValueOf(unknown))
+		}
+		return instance.Interface(), nil
+	}
+
+	fields, err := ctx.deserializeAt(buf, base-ctx.base)
+	if err != nil {
+		return nil, err
+	}
+	return createObjectFromPlan(plan, fields)
+}
@@ -0,0 +1,138 @@
+package impl
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+type registeredPackage struct {
+	nativeType reflect.Type
+	id         byte
+	plan       *structPlan
+}
+
+// Registry retains packages known under their message IDs, used to decode
+// incoming messages. A Registry is safe for concurrent use, and may be
+// provided to a Deserializer or Decoder through DeserializerOptions, allowing
+// several independent protocols to be used by a single program.
+type Registry struct {
+	lock     sync.RWMutex
+	packages map[byte]registeredPackage
+
+	// anyTypes and anyIDs retain structs that may be retained by Any values,
+	// indexed by their IDs and types, respectively.
+	anyTypes map[uint16]*structPlan
+	anyIDs   map[reflect.Type]uint16
+}
+
+// defaultRegistry is used by RegisterPackages, and by Deserializers created
+// without a Registry.
+var defaultRegistry = NewRegistry()
+
+// NewRegistry returns a new, empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		packages: map[byte]registeredPackage{},
+		anyTypes: map[uint16]*structPlan{},
+		anyIDs:   map[reflect.Type]uint16{},
+	}
+}
+
+// Register registers the provided packages under their message IDs. An error
+// is returned in case an ID is already in use, or in case annotations of a
+// package do not match its fields, as reported by ValidateSchema. In both
+// cases, none of the provided packages are registered.
+func (r *Registry) Register(pkgs ...SerializablePackage) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	pending := make(map[byte]registeredPackage, len(pkgs))
+	for _, pkg := range pkgs {
+		id := pkg.LudwiegID()
+		_, registered := r.packages[id]
+		if _, ok := pending[id]; ok || registered {
+			return fmt.Errorf("illegal attempt to register two packages with same id: %#v", id)
+		}
+
+		if err := ValidateSchema(pkg); err != nil {
+			return fmt.Errorf("illegal attempt to register package %#v: %w", id, err)
+		}
+		plan, err := planFor(reflect.TypeOf(pkg))
+		if err != nil {
+			return fmt.Errorf("illegal attempt to register package %#v: %w", id, err)
+		}
+
+		pending[id] = registeredPackage{
+			id:         id,
+			plan:       plan,
+			nativeType: plan.typ,
+		}
+	}
+
+	for id, pkg := range pending {
+		r.packages[id] = pkg
+	}
+	return nil
+}
+
+// Unregister removes the package registered under the provided ID, if any.
+func (r *Registry) Unregister(id byte) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.packages, id)
+}
+
+// Lookup returns the type of the package registered under the provided ID,
+// and whether it was found.
+func (r *Registry) Lookup(id byte) (reflect.Type, bool) {
+	pkg, ok := r.lookup(id)
+	return pkg.nativeType, ok
+}
+
+func (r *Registry) lookup(id byte) (registeredPackage, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	pkg, ok := r.packages[id]
+	return pkg, ok
+}
+
+// RegisterAnyType registers a struct under the provided ID, allowing it, and
+// arrays of it, to be retained by Any values. Values are serialised using the
+// Registry provided to the Encoder, and decoded using the Registry provided to
+// the Deserializer.
+func (r *Registry) RegisterAnyType(id uint16, s Serializable) error {
+	if err := ValidateSchema(s); err != nil {
+		return fmt.Errorf("illegal attempt to register Any type %#v: %w", id, err)
+	}
+	plan, err := planFor(reflect.TypeOf(s))
+	if err != nil {
+		return fmt.Errorf("illegal attempt to register Any type %#v: %w", id, err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.anyTypes[id]; ok {
+		return fmt.Errorf("illegal attempt to register two Any types with same id: %#v", id)
+	}
+	if _, ok := r.anyIDs[plan.typ]; ok {
+		return fmt.Errorf("illegal attempt to register Any type %s twice", plan.typ)
+	}
+	r.anyTypes[id] = plan
+	r.anyIDs[plan.typ] = id
+	return nil
+}
+
+func (r *Registry) anyType(id uint16) (*structPlan, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	plan, ok := r.anyTypes[id]
+	return plan, ok
+}
+
+func (r *Registry) anyTypeID(t reflect.Type) (uint16, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	id, ok := r.anyIDs[t]
+	return id, ok
+}
@@ -0,0 +1,198 @@
+package impl
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// protocolGoTypes maps simple protocol types to the Go type of fields
+// retaining them.
+var protocolGoTypes = map[ProtocolType]reflect.Type{
+	TypeUint8:     reflect.TypeOf((*LudwiegUint8)(nil)),
+	TypeUint32:    reflect.TypeOf((*LudwiegUint32)(nil)),
+	TypeUint64:    reflect.TypeOf((*LudwiegUint64)(nil)),
+	TypeDouble:    reflect.TypeOf((*LudwiegDouble)(nil)),
+	TypeString:    reflect.TypeOf((*LudwiegString)(nil)),
+	TypeBlob:      reflect.TypeOf([]byte(nil)),
+	TypeBool:      reflect.TypeOf((*LudwiegBool)(nil)),
+	TypeUUID:      reflect.TypeOf((*LudwiegUUID)(nil)),
+	TypeAny:       reflect.TypeOf((*LudwiegAny)(nil)),
+	TypeDynInt:    reflect.TypeOf((*LudwiegDynInt)(nil)),
+	TypeUint16:    reflect.TypeOf((*LudwiegUint16)(nil)),
+	TypeInt8:      reflect.TypeOf((*LudwiegInt8)(nil)),
+	TypeInt16:     reflect.TypeOf((*LudwiegInt16)(nil)),
+	TypeInt32:     reflect.TypeOf((*LudwiegInt32)(nil)),
+	TypeInt64:     reflect.TypeOf((*LudwiegInt64)(nil)),
+	TypeFloat:     reflect.TypeOf((*LudwiegFloat)(nil)),
+	TypeTimestamp: reflect.TypeOf((*LudwiegTimestamp)(nil)),
+	TypeDuration:  reflect.TypeOf((*LudwiegDuration)(nil)),
+}
+
+// SchemaError describes all mismatches found between the annotations returned
+// by LudwiegMeta and the fields of the structures they describe.
+type SchemaError struct {
+	// Type is the validated structure.
+	Type reflect.Type
+
+	// Mismatches describes each problem found, prefixed by the field it
+	// refers to, such as "TestSub.FieldK".
+	Mismatches []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrSchemaMismatch, e.Type, strings.Join(e.Mismatches, "; "))
+}
+
+func (e *SchemaError) Unwrap() error {
+	return ErrSchemaMismatch
+}
+
+// ValidateSchema checks whether annotations returned by LudwiegMeta match the
+// fields of the provided structure, and of every structure it refers to,
+// returning a *SchemaError describing all mismatches found.
+func ValidateSchema(s Serializable) error {
+	t := reflect.TypeOf(s)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	v := schemaValidator{visited: map[reflect.Type]bool{}}
+	v.validateStruct(t)
+	if len(v.mismatches) == 0 {
+		return nil
+	}
+	return &SchemaError{Type: t, Mismatches: v.mismatches}
+}
+
+type schemaValidator struct {
+	visited    map[reflect.Type]bool
+	mismatches []string
+}
+
+func (v *schemaValidator) report(t reflect.Type, field string, format string, args ...interface{}) {
+	v.mismatches = append(v.mismatches, qualifiedName(t, field)+": "+fmt.Sprintf(format, args...))
+}
+
+func (v *schemaValidator) validateStruct(t reflect.Type) {
+	if v.visited[t] {
+		return
+	}
+	v.visited[t] = true
+
+	if t.Kind() != reflect.Struct {
+		v.report(t, "", "expected struct, found %s", t)
+		return
+	}
+	annotations, err := extractAnnotationsFromType(t)
+	if err != nil {
+		v.report(t, "", "%s", err)
+		return
+	}
+	indexes, _, err := annotatedFields(t)
+	if err != nil {
+		v.report(t, "", "%s", err)
+	}
+	if len(annotations) != len(indexes) {
+		v.report(t, "", "%d annotations for %d fields", len(annotations), len(indexes))
+	}
+	if _, err := annotationTags(annotations); err != nil {
+		v.report(t, "", "%s", err)
+	}
+
+	for i, annotation := range annotations {
+		if i >= len(indexes) {
+			break
+		}
+		field := t.Field(indexes[i])
+		v.validateField(t, field.Name, field.Type, annotation)
+	}
+}
+
+func (v *schemaValidator) validateField(t reflect.Type, field string, fieldType reflect.Type, annotation LudwiegTypeAnnotation) {
+	switch annotation.Type {
+	case TypeStruct:
+		if !v.validateStructPointer(t, field, fieldType) {
+			return
+		}
+		v.validateStruct(fieldType.Elem())
+	case TypeArray:
+		if fieldType.Kind() != reflect.Slice {
+			v.report(t, field, "expected slice, found %s", fieldType)
+			return
+		}
+		if _, err := annotation.fixedArraySize(); err != nil {
+			v.report(t, field, "%s", err)
+		} else if annotation.ArrayCompact && annotation.ArraySize == "*" {
+			v.report(t, field, "compact arrays must declare a fixed size")
+		}
+		if annotation.ArrayElem != nil {
+			v.validateField(t, field+"[]", fieldType.Elem(), *annotation.ArrayElem)
+			return
+		}
+		v.validateItems(t, field, fieldType.Elem(), annotation.ArrayType, annotation.ArrayUserType, "ArrayUserType")
+	case TypeMap:
+		if fieldType.Kind() != reflect.Map {
+			v.report(t, field, "expected map, found %s", fieldType)
+			return
+		}
+		if expected, ok := mapKeyGoType(annotation.MapKeyType); !ok {
+			v.report(t, field, "unsupported map key type %s", annotation.MapKeyType)
+		} else if fieldType.Key() != expected {
+			v.report(t, field, "expected keys of type %s, found %s", expected, fieldType.Key())
+		}
+		v.validateItems(t, field, fieldType.Elem(), annotation.MapValueType, annotation.MapValueUserType, "MapValueUserType")
+	default:
+		expected, ok := protocolGoTypes[annotation.Type]
+		if !ok {
+			v.report(t, field, "unsupported type %s", annotation.Type)
+			return
+		}
+		if fieldType != expected {
+			v.report(t, field, "expected %s, found %s", expected, fieldType)
+		}
+	}
+}
+
+// validateItems checks items of an array, or values of a map, annotated with
+// the provided protocol type and, for structs, user type.
+func (v *schemaValidator) validateItems(t reflect.Type, field string, itemType reflect.Type, protocolType ProtocolType, userType reflect.Type, userTypeName string) {
+	if protocolType != TypeStruct {
+		expected, ok := protocolGoTypes[protocolType]
+		if !ok {
+			v.report(t, field, "unsupported item type %s", protocolType)
+			return
+		}
+		if itemType != expected {
+			v.report(t, field, "expected items of type %s, found %s", expected, itemType)
+		}
+		return
+	}
+
+	if userType == nil {
+		v.report(t, field, "missing %s for structs", userTypeName)
+		return
+	}
+	if userType != itemType {
+		v.report(t, field, "%s %s does not match items of type %s", userTypeName, userType, itemType)
+		return
+	}
+	if !v.validateStructPointer(t, field, itemType) {
+		return
+	}
+	v.validateStruct(itemType.Elem())
+}
+
+// validateStructPointer checks whether typ is a pointer to a Serializable
+// struct.
+func (v *schemaValidator) validateStructPointer(t reflect.Type, field string, typ reflect.Type) bool {
+	if typ.Kind() != reflect.Ptr || typ.Elem().Kind() != reflect.Struct {
+		v.report(t, field, "expected pointer to struct, found %s", typ)
+		return false
+	}
+	if !typ.Implements(serializableType) {
+		v.report(t, field, "%s does not implement Serializable", typ)
+		return false
+	}
+	return true
+}
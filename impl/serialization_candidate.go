@@ -25,4 +25,16 @@ type serializationCandidate struct {
 	// isRoot is only used by the struct serialiser, since it is shared among
 	// other generic serializers.
 	isRoot bool
+
+	// registry retains structs that may be retained by Any values. When nil,
+	// the default Registry is assumed.
+	registry *Registry
+}
+
+// anyTypeID returns the ID t was registered under through RegisterAnyType.
+func (c *serializationCandidate) anyTypeID(t reflect.Type) (uint16, bool) {
+	if c.registry == nil {
+		return defaultRegistry.anyTypeID(t)
+	}
+	return c.registry.anyTypeID(t)
 }
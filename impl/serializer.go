@@ -11,38 +11,11 @@ func illegalSetterValueError(t string) error {
 }
 
 // SerializeMessage serializes a given SerializablePackage and messageID into a
-// transferable byte buffer
+// transferable byte buffer. Use an Encoder to write messages directly into a
+// stream.
 func SerializeMessage(p SerializablePackage, messageID byte) (bufPtr *bytes.Buffer, err error) {
 	var buf bytes.Buffer
-
-	// 1. Write Magic Bytes
-	buf.Write(magicBytes)
-
-	// 2. Write Message Meta
-	meta := MessageMetadata{
-		ProtocolVersion: 0x01, // Version 1
-		MessageID:       messageID,
-		PackageType:     p.LudwiegID(),
-	}
-	meta.writeTo(&buf)
-
-	value := reflect.ValueOf(p)
-
-	var tmpBuf bytes.Buffer
-
-	// 3. Prepare Fields
-	err = serializeStruct(&serializationCandidate{
-		isRoot:    true,
-		writeType: false,
-		value:     &value,
-	}, &tmpBuf)
-
-	// 4. Write Package Size
-	writeSize(uint64(tmpBuf.Len()), &buf)
-
-	// 5. Write Payload
-	buf.Write(tmpBuf.Bytes())
-
+	err = NewEncoder(&buf).Encode(p, messageID)
 	return &buf, err
 }
 
@@ -61,7 +34,7 @@ func SerializeNonMessage(p Serializable) (bufPtr *bytes.Buffer, err error) {
 			Empty:          false,
 			Known:          true,
 			LengthPrefixed: true,
-			Type:           TypeStruct,
+			Type:           byte(TypeStruct),
 		},
 	}, &tmpBuf)
 
@@ -74,7 +47,13 @@ func serialize(buf *bytes.Buffer, c *serializationCandidate) error {
 	value := c.value
 	annotation := c.annotation
 
-	if value.IsNil() {
+	if isNil(value) {
+		if annotation.Type == TypeArray {
+			// Empty arrays must still match their declared size.
+			if err := annotation.checkArraySize(0); err != nil {
+				return err
+			}
+		}
 		if c.writeType {
 			// Empty values are handled by just writing the protocol type to the
 			// stream with the IsEmpty bit set.
@@ -95,6 +74,8 @@ func serialize(buf *bytes.Buffer, c *serializationCandidate) error {
 		serializer = serializeUint32
 	case TypeUint64:
 		serializer = serializeUint64
+	case TypeFloat:
+		serializer = serializeFloat
 	case TypeDouble:
 		serializer = serializeDouble
 	case TypeString:
@@ -105,6 +86,8 @@ func serialize(buf *bytes.Buffer, c *serializationCandidate) error {
 		serializer = serializeBool
 	case TypeArray:
 		serializer = serializeArray
+	case TypeMap:
+		serializer = serializeMap
 	case TypeUUID:
 		serializer = serializeUUID
 	case TypeStruct:
@@ -113,6 +96,20 @@ func serialize(buf *bytes.Buffer, c *serializationCandidate) error {
 		serializer = serializeAny
 	case TypeDynInt:
 		serializer = serializeDynInt
+	case TypeUint16:
+		serializer = serializeUint16
+	case TypeInt8:
+		serializer = serializeInt8
+	case TypeInt16:
+		serializer = serializeInt16
+	case TypeInt32:
+		serializer = serializeInt32
+	case TypeInt64:
+		serializer = serializeInt64
+	case TypeTimestamp:
+		serializer = serializeTimestamp
+	case TypeDuration:
+		serializer = serializeDuration
 	}
 
 	if serializer == nil {
@@ -121,3 +118,13 @@ func serialize(buf *bytes.Buffer, c *serializationCandidate) error {
 
 	return serializer(c, buf)
 }
+
+// isNil reports whether value is nil, as long as its kind can be compared
+// against nil.
+func isNil(value *reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+		return value.IsNil()
+	}
+	return false
+}
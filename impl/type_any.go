@@ -3,7 +3,9 @@ package impl
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"reflect"
+	"strings"
 )
 
 // LudwiegAny is used to safely represent a nullable "any" value.
@@ -30,71 +32,57 @@ func serializeAny(c *serializationCandidate, b *bytes.Buffer) error {
 		var internalBuffer bytes.Buffer
 		reflectValue := reflect.ValueOf(v.Value)
 
-		// Here we will need some helpers. Bear with me:
-		serializeArray := func(t ProtocolType) error {
-			if reflectValue.Len() == 0 {
-				return fmt.Errorf("type Any cannot serialize empty array")
-			}
-			meta := metaTypeFromByte(byte(TypeArray))
-			return serializeArray(&serializationCandidate{
-				annotation: &LudwiegTypeAnnotation{ArrayType: t, ArraySize: "*", Type: TypeArray},
-				meta:       &meta,
-				value:      &reflectValue,
-				writeType:  true,
-			}, &internalBuffer)
+		if !reflectValue.IsValid() {
+			return fmt.Errorf("type Any cannot retain untyped nil values")
 		}
 
-		serializeSimple := func(f serializerFunc, t ProtocolType) error {
-			if t == TypeStruct {
-				return fmt.Errorf("type Any cannot retain an struct")
+		serializeAnnotated := func(annotation *LudwiegTypeAnnotation) error {
+			if (annotation.Type == TypeArray || annotation.Type == TypeMap) && reflectValue.Len() == 0 {
+				return fmt.Errorf("type Any cannot serialize empty %s", strings.ToLower(annotation.Type.String()))
 			}
-			meta := metaTypeFromByte(byte(t))
-			return f(&serializationCandidate{
-				annotation: &LudwiegTypeAnnotation{Type: t},
-				meta:       &meta,
+			return serialize(&internalBuffer, &serializationCandidate{
+				annotation: annotation,
+				meta:       annotation.metaProtocolByte(),
 				value:      &reflectValue,
 				writeType:  true,
-			}, &internalBuffer)
+				registry:   c.registry,
+			})
 		}
 
 		var err error
-		switch v.Value.(type) {
-		case *LudwiegUint8:
-			err = serializeSimple(serializeUint8, TypeUint8)
-		case *LudwiegUint32:
-			err = serializeSimple(serializeUint32, TypeUint32)
-		case *LudwiegUint64:
-			err = serializeSimple(serializeUint64, TypeUint64)
-		case *LudwiegDouble:
-			err = serializeSimple(serializeDouble, TypeDouble)
-		case *LudwiegString:
-			err = serializeSimple(serializeString, TypeString)
-		case *LudwiegUUID:
-			err = serializeSimple(serializeUUID, TypeUUID)
-		case []byte:
-			err = serializeSimple(serializeBlob, TypeBlob)
-		case [][]byte:
-			err = serializeArray(TypeBlob)
-		case []*LudwiegUint8:
-			err = serializeArray(TypeUint8)
-		case []*LudwiegUint32:
-			err = serializeArray(TypeUint32)
-		case []*LudwiegUint64:
-			err = serializeArray(TypeUint64)
-		case []*LudwiegDouble:
-			err = serializeArray(TypeDouble)
-		case []*LudwiegString:
-			err = serializeArray(TypeString)
-		case []*LudwiegUUID:
-			err = serializeArray(TypeUUID)
-		default:
+		if annotation, ok := anyAnnotation(reflectValue.Type()); ok {
+			err = serializeAnnotated(annotation)
+		} else {
 			// Oh my.
-			// Probs a struct. Assume a pointer, panic otherwise.
-			if reflectValue.Kind() != reflect.Ptr {
+			// Probs a struct, or an array of structs. Assume pointers, panic
+			// otherwise.
+			structType := reflectValue.Type()
+			if structType.Kind() == reflect.Slice {
+				structType = structType.Elem()
+			}
+			if structType.Kind() != reflect.Ptr {
 				return fmt.Errorf("cannot serialize non-pointer 'Any' value")
 			}
+			if reflectValue.Kind() == reflect.Ptr && reflectValue.IsNil() {
+				// Nil structs would be written as empty ones, which cannot
+				// be told apart from missing values when decoding.
+				return fmt.Errorf("type Any cannot retain nil %s values", structType)
+			}
+
+			// Structs are prefixed by the ID they were registered under
+			// through RegisterAnyType, so decoders are able to rebuild them.
+			id, ok := c.anyTypeID(structType.Elem())
+			if !ok {
+				return fmt.Errorf("type Any cannot retain unregistered struct %s", structType.Elem())
+			}
+			internalBuffer.WriteByte(byte(TypeStruct))
+			writeUint16(id, &internalBuffer)
 
-			err = serializeSimple(serializeStruct, TypeStruct)
+			if reflectValue.Kind() == reflect.Slice {
+				err = serializeAnnotated(&LudwiegTypeAnnotation{Type: TypeArray, ArrayType: TypeStruct, ArraySize: "*"})
+			} else {
+				err = serializeAnnotated(&LudwiegTypeAnnotation{Type: TypeStruct})
+			}
 		}
 		if err != nil {
 			return err
@@ -108,22 +96,197 @@ func serializeAny(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeAny(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeAny(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegAny{}, nil
 	}
 
-	size := int(readSize(offset, b))
-	tmpBuf := b[*offset : *offset+size]
-	incrSize(offset, size)
+	size, err := ctx.readSize(TypeAny, b, offset)
+	if err != nil {
+		return nil, err
+	}
+	start := *offset
+	tmpBuf, err := ctx.read(TypeAny, b, offset, size)
+	if err != nil {
+		return nil, err
+	}
 
-	val, err := deserialize(tmpBuf)
+	var plan *structPlan
+	if len(tmpBuf) > 0 && metaTypeFromByte(tmpBuf[0]).ManagedType == TypeStruct {
+		// Structs are prefixed by their registered ID.
+		innerOffset := 1
+		rawID, err := ctx.read(TypeAny, tmpBuf, &innerOffset, 2)
+		if err != nil {
+			return nil, err
+		}
+		id := readUint16(rawID)
+		var ok bool
+		if plan, ok = ctx.opts.registry().anyType(id); !ok {
+			return nil, fmt.Errorf("%w: unregistered Any struct %#v", ErrUnknownType, id)
+		}
+		start += innerOffset
+		tmpBuf = tmpBuf[innerOffset:]
+	}
+
+	val, err := ctx.deserializeAt(tmpBuf, start)
 	if err != nil {
 		return nil, err
 	}
+	if len(val) == 0 || (plan != nil && val[0] == nil) {
+		return nil, ctx.error(TypeAny, start, io.ErrUnexpectedEOF)
+	}
+
+	value := val[0]
+	if plan != nil {
+		innerType := metaTypeFromByte(tmpBuf[0]).ManagedType
+		if value, err = buildAnyStruct(ctx, plan, innerType, value); err != nil {
+			return nil, ctx.error(TypeAny, start, err)
+		}
+	} else {
+		value = typedAnyValue(value)
+	}
 
 	return &LudwiegAny{
 		HasValue: true,
-		Value:    val[0],
+		Value:    value,
 	}, nil
 }
+
+// goProtocolTypes maps Go types of fields to the simple protocol types they
+// retain. It is the inverse of protocolGoTypes.
+var goProtocolTypes = func() map[reflect.Type]ProtocolType {
+	result := make(map[reflect.Type]ProtocolType, len(protocolGoTypes))
+	for t, goType := range protocolGoTypes {
+		result[goType] = t
+	}
+	return result
+}()
+
+// anyAnnotation returns the annotation describing values of type t retained
+// by Any values: simple types, arrays of them (including nested arrays), and
+// maps of them. Structs are not described, as they must be registered
+// through RegisterAnyType.
+func anyAnnotation(t reflect.Type) (*LudwiegTypeAnnotation, bool) {
+	if protocolType, ok := goProtocolTypes[t]; ok {
+		return &LudwiegTypeAnnotation{Type: protocolType}, true
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		elem, ok := anyAnnotation(t.Elem())
+		if !ok {
+			return nil, false
+		}
+		switch elem.Type {
+		case TypeArray:
+			return &LudwiegTypeAnnotation{Type: TypeArray, ArrayType: TypeArray, ArraySize: "*", ArrayElem: elem}, true
+		case TypeMap:
+			return nil, false
+		}
+		return &LudwiegTypeAnnotation{Type: TypeArray, ArrayType: elem.Type, ArraySize: "*"}, true
+	case reflect.Map:
+		valueType, ok := goProtocolTypes[t.Elem()]
+		if !ok {
+			return nil, false
+		}
+		for keyType := range mapKeyTypes {
+			if goType, _ := mapKeyGoType(keyType); goType == t.Key() {
+				return &LudwiegTypeAnnotation{Type: TypeMap, MapKeyType: keyType, MapValueType: valueType}, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// typedAnyValue converts arrays and maps decoded from Any values into the
+// typed slices and maps accepted by Any, so values retained by it survive a
+// round trip. Values whose items cannot share a single type are returned
+// as-is.
+func typedAnyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case []interface{}:
+		items := make([]reflect.Value, len(v))
+		for i, item := range v {
+			items[i] = reflect.ValueOf(typedAnyValue(item))
+		}
+		itemType := commonAnyType(items)
+		if itemType == nil {
+			return value
+		}
+		result := reflect.MakeSlice(reflect.SliceOf(itemType), len(items), len(items))
+		for i, item := range items {
+			result.Index(i).Set(item)
+		}
+		return result.Interface()
+	case map[interface{}]interface{}:
+		keys := make([]reflect.Value, 0, len(v))
+		values := make([]reflect.Value, 0, len(v))
+		for key, item := range v {
+			keys = append(keys, reflect.ValueOf(key))
+			values = append(values, reflect.ValueOf(item))
+		}
+		keyType, valueType := commonAnyType(keys), commonAnyType(values)
+		if keyType == nil || valueType == nil {
+			return value
+		}
+		result := reflect.MakeMapWithSize(reflect.MapOf(keyType, valueType), len(keys))
+		for i, key := range keys {
+			result.SetMapIndex(key, values[i])
+		}
+		return result.Interface()
+	}
+	return value
+}
+
+// commonAnyType returns the type shared by all provided values, or nil when
+// there is none. Empty arrays, which carry no type information of their own,
+// are converted into empty slices of the shared type.
+func commonAnyType(values []reflect.Value) reflect.Type {
+	var result reflect.Type
+	for _, v := range values {
+		if !v.IsValid() {
+			return nil
+		}
+		if result == nil && !isEmptyDecodedArray(v) {
+			result = v.Type()
+		}
+	}
+	if result == nil {
+		return nil
+	}
+	for i, v := range values {
+		if v.Type() == result {
+			continue
+		}
+		if result.Kind() != reflect.Slice || !isEmptyDecodedArray(v) {
+			return nil
+		}
+		values[i] = reflect.MakeSlice(result, 0, 0)
+	}
+	return result
+}
+
+func isEmptyDecodedArray(v reflect.Value) bool {
+	arr, ok := v.Interface().([]interface{})
+	return ok && len(arr) == 0
+}
+
+// buildAnyStruct builds the struct, or array of structs, retained by an Any
+// value using the provided plan.
+func buildAnyStruct(ctx *decodeContext, plan *structPlan, t ProtocolType, value interface{}) (interface{}, error) {
+	switch t {
+	case TypeStruct:
+		fields, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: expected struct, found %T", ErrSchemaMismatch, value)
+		}
+		return createObjectFromPlan(plan, fields)
+	case TypeArray:
+		arr, err := buildArray(nil, ctx.pathString(), reflect.SliceOf(reflect.PtrTo(plan.typ)), nil, plan, value)
+		if err != nil {
+			return nil, err
+		}
+		return arr.Interface(), nil
+	}
+	return nil, fmt.Errorf("%w: expected struct, found %s", ErrSchemaMismatch, t)
+}
@@ -3,11 +3,12 @@ package impl
 import (
 	"bytes"
 	"fmt"
-	"strconv"
+	"reflect"
 )
 
-/* Ludwieg arrays cannot be nil, but they may be empty. Hence the lack of
-LudwiegArray */
+/* Ludwieg arrays are represented by native slices, which are also nullable.
+Nil arrays are written with the empty bit set, while empty arrays are written
+without items. Hence the lack of LudwiegArray */
 
 func serializeArray(c *serializationCandidate, b *bytes.Buffer) error {
 	if c.writeType {
@@ -15,27 +16,37 @@ func serializeArray(c *serializationCandidate, b *bytes.Buffer) error {
 	}
 	// Here we may want to perform extra checks, such as if we have correct
 	// types and that our size is sane.
-	rawArraySize := c.annotation.ArraySize
-	arrayType := c.annotation.ArrayType
+	arrTypeAnnotation := c.annotation.arrayElem()
+	arrayType := arrTypeAnnotation.Type
 	if arrayType == TypeUnknown {
 		return fmt.Errorf("invalid array type %#v", arrayType)
 	}
-	if rawArraySize != "*" {
-		_, err := strconv.Atoi(rawArraySize)
-		if err != nil {
-			return fmt.Errorf("invalid array size %s", rawArraySize)
-		}
+	fixedSize, err := c.annotation.fixedArraySize()
+	if err != nil {
+		return err
 	}
 
 	arrayLogicalSize := c.value.Len()
+	if err := c.annotation.checkArraySize(arrayLogicalSize); err != nil {
+		return err
+	}
 	array := c.value.Slice(0, arrayLogicalSize)
 	// array will always be an slice of pointers.
 	var arrBuf bytes.Buffer
-	arrTypeAnnotation := &LudwiegTypeAnnotation{Type: arrayType}
 	arrAnnotationByte := arrTypeAnnotation.metaProtocolByte()
 	for i := 0; i < arrayLogicalSize; i++ {
 		itemVal := array.Index(i)
-		err := serialize(&arrBuf, &serializationCandidate{&itemVal, arrTypeAnnotation, arrAnnotationByte, false, false})
+		if (arrayType == TypeArray || arrayType == TypeBlob) && itemVal.IsNil() {
+			// Items are written without their type, hence lacking the
+			// empty bit. Nil inner arrays and blobs are written as empty
+			// ones.
+			itemVal = reflect.MakeSlice(itemVal.Type(), 0, 0)
+		} else if isNil(&itemVal) {
+			// Other nil items would be written as no bytes at all, while
+			// still being accounted by the item count.
+			return fmt.Errorf("array items cannot be nil (index %d)", i)
+		}
+		err := serialize(&arrBuf, &serializationCandidate{&itemVal, arrTypeAnnotation, arrAnnotationByte, false, false, c.registry})
 		if err != nil {
 			return err
 		}
@@ -43,12 +54,19 @@ func serializeArray(c *serializationCandidate, b *bytes.Buffer) error {
 
 	writeSize(uint64(arrBuf.Len()), b)
 	b.WriteByte(arrAnnotationByte.byte())
-	writeSize(uint64(arrayLogicalSize), b)
+	if c.annotation.ArrayCompact && fixedSize >= 0 {
+		// Compact arrays omit their item count, which is known by the
+		// schema. It is written as zero, indicating decoders must count
+		// items by themselves.
+		writeSize(0, b)
+	} else {
+		writeSize(uint64(arrayLogicalSize), b)
+	}
 	b.Write(arrBuf.Bytes())
 	return nil
 }
 
-func decodeArray(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeArray(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		// The main decoder, responsible for setting fields may either choose to
 		// panic, or to leave the slice field with its initial value.
@@ -57,36 +75,108 @@ func decodeArray(t metaProtocolByte, b []byte, offset *int) (interface{}, error)
 
 	// Ludwieg arrays are weird monsters. Let's decode it:
 	// 1. Size of the array buffer containing array data
-	size := int(readSize(offset, b))
+	size, err := ctx.readSize(TypeArray, b, offset)
+	if err != nil {
+		return nil, err
+	}
 
 	// 2. Type being read
-	arrayType := metaTypeFromByte(b[incr(offset)])
+	rawType, err := ctx.read(TypeArray, b, offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	arrayType := metaTypeFromByte(rawType[0])
 
 	// 3. the virtual size of the array represents how many items
 	// the decoder is supposed to yield
-	virtualSize := int(readSize(offset, b))
-
-	result := make([]interface{}, 0, virtualSize)
+	virtualSize, err := ctx.readSize(TypeArray, b, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimit("array length", virtualSize, ctx.opts.MaxArrayItems); err != nil {
+		return nil, err
+	}
 
 	decoder, ok := registeredTypeDecoder[arrayType.ManagedType]
 
-	tmpBuffer := b[*offset : *offset+size]
-
-	incrSize(offset, size)
+	start := *offset
+	tmpBuffer, err := ctx.read(TypeArray, b, offset, size)
+	if err != nil {
+		return nil, err
+	}
 
 	if !ok {
-		return nil, fmt.Errorf("unknown decoder for type %#v", arrayType)
+		return nil, fmt.Errorf("%w %#v", ErrUnknownType, arrayType.Type)
+	}
+	if err := checkItemType(arrayType); err != nil {
+		return nil, err
 	}
 
+	ctx.base += start
+	defer func() { ctx.base -= start }()
+
+	// Each item takes at least one byte, so the declared count is only
+	// trusted up to the size of data actually received.
+	result := make([]interface{}, 0, int(capacityFor(virtualSize, tmpBuffer)))
+	itemType, itemAnnotation := ctx.item()
 	innerOffset := 0
 
 	for innerOffset < len(tmpBuffer) {
-		i, err := decoder(arrayType, tmpBuffer, &innerOffset)
+		if err := checkLimit("array length", uint64(len(result)+1), ctx.opts.MaxArrayItems); err != nil {
+			return nil, err
+		}
+		itemOffset := innerOffset
+		var i interface{}
+		err := ctx.push(fmt.Sprintf("[%d]", len(result)), itemType, itemAnnotation)
+		if err == nil {
+			i, err = decoder(ctx, arrayType, tmpBuffer, &innerOffset)
+		}
+		if err == nil {
+			err = checkItemProgress(itemOffset, innerOffset)
+		}
+		if err != nil {
+			err = ctx.error(arrayType.ManagedType, itemOffset, err)
+		}
+		ctx.pop()
 		if err != nil {
 			return nil, err
 		}
 		result = append(result, i)
 	}
 
+	// Compact arrays declare no items, leaving the count to the schema.
+	if uint64(len(result)) != virtualSize && (virtualSize != 0 || !ctx.compactArray()) {
+		return nil, fmt.Errorf("array declares %d items, found %d", virtualSize, len(result))
+	}
+
 	return result, nil
 }
+
+// capacityFor returns how many items should be preallocated for a collection
+// declaring count items, whose data is held by buf.
+func capacityFor(count uint64, buf []byte) uint64 {
+	if available := uint64(len(buf)); count > available {
+		return available
+	}
+	return count
+}
+
+// checkItemType returns an error in case items of an array, or keys and values
+// of a map, declare the empty bit. Items are never written with it, and their
+// decoders would yield empty values without consuming any bytes.
+func checkItemType(t metaProtocolByte) error {
+	if t.Empty {
+		return fmt.Errorf("%w: items of type %s cannot be empty", ErrUnknownType, t.ManagedType)
+	}
+	return nil
+}
+
+// checkItemProgress returns an error in case decoding an item starting at
+// start did not consume any bytes, which would otherwise cause decoders to
+// yield items indefinitely.
+func checkItemProgress(start, offset int) error {
+	if offset <= start {
+		return fmt.Errorf("item at offset %d consumed no bytes", start)
+	}
+	return nil
+}
@@ -20,14 +20,24 @@ func serializeBlob(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeBlob(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeBlob(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
-		return []byte{}, nil
+		// Blobs written with the empty bit set are nil, as opposed to
+		// zero-length blobs, which are decoded as empty slices.
+		return nil, nil
 	}
 
-	size := int(readSize(offset, b))
-	tmpBuf := b[*offset : *offset+size]
-	incrSize(offset, size)
+	size, err := ctx.readSize(TypeBlob, b, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimit("blob size", size, ctx.opts.MaxPayloadSize); err != nil {
+		return nil, err
+	}
+	tmpBuf, err := ctx.read(TypeBlob, b, offset, size)
+	if err != nil {
+		return nil, err
+	}
 
 	return tmpBuf, nil
 }
@@ -34,12 +34,16 @@ func serializeBool(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeBool(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeBool(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegBool{}, nil
 	}
+	tmpBuf, err := ctx.read(TypeBool, b, offset, 1)
+	if err != nil {
+		return nil, err
+	}
 	return &LudwiegBool{
 		HasValue: true,
-		Value:    b[incrSize(offset, 1)] == 0x1,
+		Value:    tmpBuf[0] == 0x1,
 	}, nil
 }
@@ -31,12 +31,14 @@ func serializeDouble(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeDouble(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeDouble(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegDouble{}, nil
 	}
-	tmpBuf := b[*offset : *offset+8]
-	incrSize(offset, 8)
+	tmpBuf, err := ctx.read(TypeDouble, b, offset, 8)
+	if err != nil {
+		return nil, err
+	}
 
 	return &LudwiegDouble{
 		HasValue: true,
@@ -0,0 +1,49 @@
+package impl
+
+import (
+	"bytes"
+	"time"
+)
+
+// LudwiegDuration is used to safely represent a nullable time.Duration value
+type LudwiegDuration struct {
+	HasValue bool
+	Value    time.Duration
+}
+
+// Duration returns a safe nullable Duration value
+func Duration(v time.Duration) *LudwiegDuration {
+	return &LudwiegDuration{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+func serializeDuration(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegDuration); ok {
+		// Durations are encoded as nanoseconds
+		writeUint64(uint64(v.Value), b)
+	} else {
+		return illegalSetterValueError("time.Duration")
+	}
+	return nil
+}
+
+func decodeDuration(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegDuration{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeDuration, b, offset, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LudwiegDuration{
+		HasValue: true,
+		Value:    time.Duration(readUint64(tmpBuf)),
+	}, nil
+}
@@ -2,18 +2,19 @@ package impl
 
 import (
 	"bytes"
+	"fmt"
 	"math"
 )
 
-// This defines the constant margin of error when transforming possible float
-// values into another kind.
-const dynintEpsilon = 1e-9
-
 // LudwiegDynInt is used to represent an integer field with varying size,
 // automatically setting its precision on-the-fly.
 type LudwiegDynInt struct {
-	hasValue       bool
-	value          float64
+	hasValue bool
+
+	// bits retains the exact value being represented: unsigned kinds retain
+	// their value, signed kinds retain their two's complement representation,
+	// and float kinds retain the bits of their float64 representation.
+	bits           uint64
 	UnderlyingType DynIntValueKind
 }
 
@@ -23,41 +24,40 @@ func DynInt(v interface{}) *LudwiegDynInt {
 		hasValue: true,
 	}
 	var kind DynIntValueKind
-	var value float64
+	var bits uint64
 	switch val := v.(type) {
 	case int:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromInt(int64(val))
 	case int8:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromInt(int64(val))
 	case int16:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromInt(int64(val))
 	case int32:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromInt(int64(val))
 	case int64:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromInt(val)
 	case uint:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromUint(uint64(val))
 	case uint8:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromUint(uint64(val))
 	case uint16:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromUint(uint64(val))
 	case uint32:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromUint(uint64(val))
 	case uint64:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromUint(val)
 	case float32:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromFloat(float64(val))
 	case float64:
-		kind, value = dynintInferNumberType(float64(val))
+		kind, bits = dynintFromFloat(val)
 	case nil:
 		kind = DynIntValueKindInt8
-		value = 0
 		retVal.hasValue = false
 	default:
 		panic(illegalSetterValueError("dynint"))
 	}
 
-	retVal.value = value
+	retVal.bits = bits
 	retVal.UnderlyingType = kind
 
 	return retVal
@@ -74,15 +74,17 @@ func serializeDynInt(c *serializationCandidate, b *bytes.Buffer) error {
 		switch v.UnderlyingType {
 		case DynIntValueKindInvalid:
 		case DynIntValueKindUint8, DynIntValueKindInt8:
-			b.WriteByte(byte(v.value))
+			b.WriteByte(byte(v.bits))
 		case DynIntValueKindInt16, DynIntValueKindUint16:
-			writeUint16(uint16(v.value), b)
+			writeUint16(uint16(v.bits), b)
 		case DynIntValueKindUint32, DynIntValueKindInt32:
-			writeUint32(uint32(v.value), b)
+			writeUint32(uint32(v.bits), b)
 		case DynIntValueKindUint64, DynIntValueKindInt64:
-			writeUint64(uint64(v.value), b)
-		case DynIntValueKindFloat64, DynIntValueKindFloat32:
-			writeDouble(v.value, b)
+			writeUint64(v.bits, b)
+		case DynIntValueKindFloat32:
+			writeFloat(float32(math.Float64frombits(v.bits)), b)
+		case DynIntValueKindFloat64:
+			writeUint64(v.bits, b)
 		}
 	} else {
 		return illegalSetterValueError("dynint")
@@ -90,175 +92,259 @@ func serializeDynInt(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func dynintInferNumberType(val float64) (DynIntValueKind, float64) {
-	// First, we will want to give special attention to float kinds, since
-	// we're about to drop all the fraction part of its value.
-	integer, frac := math.Modf(val)
-	if frac > dynintEpsilon && frac < 1.0-dynintEpsilon {
-		// Float.
-		if val >= -math.MaxFloat32 && val <= math.MaxFloat32 {
-			return DynIntValueKindFloat32, val
-		}
-		return DynIntValueKindFloat32, val
-	}
-
-	maxMins := [][]float64{
-		{0, math.MaxUint8},
-		{0, math.MaxUint16},
-		{0, math.MaxUint32},
-		{0, math.MaxUint64},
-		{math.MinInt8, math.MaxInt8},
-		{math.MinInt16, math.MaxInt16},
-		{math.MinInt32, math.MaxInt32},
-		{math.MinInt64, math.MaxInt64},
+// dynintFromUint returns the smallest unsigned kind able to represent val.
+func dynintFromUint(val uint64) (DynIntValueKind, uint64) {
+	switch {
+	case val <= math.MaxUint8:
+		return DynIntValueKindUint8, val
+	case val <= math.MaxUint16:
+		return DynIntValueKindUint16, val
+	case val <= math.MaxUint32:
+		return DynIntValueKindUint32, val
 	}
+	return DynIntValueKindUint64, val
+}
 
-	types := []DynIntValueKind{
-		DynIntValueKindUint8,
-		DynIntValueKindUint16,
-		DynIntValueKindUint32,
-		DynIntValueKindUint64,
-		DynIntValueKindInt8,
-		DynIntValueKindInt16,
-		DynIntValueKindInt32,
-		DynIntValueKindInt64,
+// dynintFromInt returns the smallest kind able to represent val, preferring
+// unsigned kinds for positive values.
+func dynintFromInt(val int64) (DynIntValueKind, uint64) {
+	switch {
+	case val >= 0:
+		return dynintFromUint(uint64(val))
+	case val >= math.MinInt8:
+		return DynIntValueKindInt8, uint64(val)
+	case val >= math.MinInt16:
+		return DynIntValueKindInt16, uint64(val)
+	case val >= math.MinInt32:
+		return DynIntValueKindInt32, uint64(val)
 	}
+	return DynIntValueKindInt64, uint64(val)
+}
 
-	for i, v := range maxMins {
-		if integer >= v[0] && integer <= v[1] {
-			return types[i], integer
+// dynintFromFloat returns an integer kind for values without a fraction part
+// that can be exactly represented by one, or the smallest float kind able to
+// represent val without losing precision.
+func dynintFromFloat(val float64) (DynIntValueKind, uint64) {
+	if integer, frac := math.Modf(val); frac == 0 {
+		// 2^64 and -2^63 are exactly representable as float64, hence the
+		// strict upper bounds.
+		if integer >= 0 && integer < math.MaxUint64 {
+			return dynintFromUint(uint64(integer))
+		}
+		if integer < 0 && integer >= math.MinInt64 {
+			return dynintFromInt(int64(integer))
 		}
 	}
-
-	return DynIntValueKindInvalid, 0
+	if float64(float32(val)) == val || math.IsNaN(val) {
+		return DynIntValueKindFloat32, math.Float64bits(val)
+	}
+	return DynIntValueKindFloat64, math.Float64bits(val)
 }
 
-func decodeDynInt(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeDynInt(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegDynInt{}, nil
 	}
-	dynKind := b[incrSize(offset, 1)]
-	var value float64
+	rawKind, err := ctx.read(TypeDynInt, b, offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	dynKind := rawKind[0]
+	var bits uint64
 	var tmpBuf []byte
 
 	switch DynIntValueKind(dynKind) {
-	case DynIntValueKindUint8, DynIntValueKindInt8:
-		value = float64(b[incrSize(offset, 1)])
-	case DynIntValueKindInt16, DynIntValueKindUint16:
-		tmpBuf = b[*offset : *offset+2]
-		incrSize(offset, 2)
-		value = float64(readUint16(tmpBuf))
-	case DynIntValueKindUint32, DynIntValueKindInt32:
-		tmpBuf = b[*offset : *offset+4]
-		incrSize(offset, 4)
-		value = float64(readUint32(tmpBuf))
-	case DynIntValueKindUint64, DynIntValueKindInt64:
-		tmpBuf = b[*offset : *offset+8]
-		incrSize(offset, 8)
-		value = float64(readUint64(tmpBuf))
-	case DynIntValueKindFloat64, DynIntValueKindFloat32:
-		tmpBuf = b[*offset : *offset+8]
-		incrSize(offset, 8)
-		value = readDouble(tmpBuf)
+	case DynIntValueKindUint8:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 1); err == nil {
+			bits = uint64(tmpBuf[0])
+		}
+	case DynIntValueKindInt8:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 1); err == nil {
+			bits = uint64(int64(int8(tmpBuf[0])))
+		}
+	case DynIntValueKindUint16:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 2); err == nil {
+			bits = uint64(readUint16(tmpBuf))
+		}
+	case DynIntValueKindInt16:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 2); err == nil {
+			bits = uint64(int64(int16(readUint16(tmpBuf))))
+		}
+	case DynIntValueKindUint32:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 4); err == nil {
+			bits = uint64(readUint32(tmpBuf))
+		}
+	case DynIntValueKindInt32:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 4); err == nil {
+			bits = uint64(int64(int32(readUint32(tmpBuf))))
+		}
+	case DynIntValueKindUint64, DynIntValueKindInt64, DynIntValueKindFloat64:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 8); err == nil {
+			bits = readUint64(tmpBuf)
+		}
+	case DynIntValueKindFloat32:
+		if tmpBuf, err = ctx.read(TypeDynInt, b, offset, 4); err == nil {
+			bits = math.Float64bits(float64(readFloat(tmpBuf)))
+		}
 	default:
-		return &LudwiegDynInt{}, nil
+		// The size of values of unknown kinds is unknown as well, so
+		// decoding cannot proceed past them.
+		return nil, fmt.Errorf("%w: DynInt kind %#x", ErrUnknownType, dynKind)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	result := LudwiegDynInt{
 		hasValue:       true,
-		value:          value,
+		bits:           bits,
 		UnderlyingType: DynIntValueKind(dynKind),
 	}
 
 	return &result, nil
 }
 
-// Next we need to coerce back to known types
+// Next we need to coerce back to known types. Accessors return
+// ErrDynIntOverflow whenever the retained value cannot be represented by the
+// requested type.
 
-// Uint8 returns the internal representation of this type as an uint8
-func (d *LudwiegDynInt) Uint8() uint8 {
-	if !d.hasValue {
-		return 0
+func (d *LudwiegDynInt) isSigned() bool {
+	switch d.UnderlyingType {
+	case DynIntValueKindInt8, DynIntValueKindInt16, DynIntValueKindInt32, DynIntValueKindInt64:
+		return true
 	}
-	return uint8(d.value)
+	return false
 }
 
-// Uint16 returns the internal representation of this type as an uint16
-func (d *LudwiegDynInt) Uint16() uint16 {
-	if !d.hasValue {
-		return 0
+func (d *LudwiegDynInt) isFloat() bool {
+	return d.UnderlyingType == DynIntValueKindFloat32 || d.UnderlyingType == DynIntValueKindFloat64
+}
+
+func (d *LudwiegDynInt) overflowError(to string) error {
+	return fmt.Errorf("%w: cannot represent %s value as %s", ErrDynIntOverflow, d.UnderlyingType, to)
+}
+
+// integer returns the retained value as an int64 when negative, or as an
+// uint64 otherwise.
+func (d *LudwiegDynInt) integer(to string) (neg int64, pos uint64, err error) {
+	switch {
+	case !d.hasValue:
+		return 0, 0, nil
+	case d.isFloat():
+		f := math.Float64frombits(d.bits)
+		if _, frac := math.Modf(f); frac != 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return 0, 0, d.overflowError(to)
+		}
+		if f >= 0 && f < math.MaxUint64 {
+			return 0, uint64(f), nil
+		}
+		if f < 0 && f >= math.MinInt64 {
+			return int64(f), 0, nil
+		}
+		return 0, 0, d.overflowError(to)
+	case d.isSigned() && int64(d.bits) < 0:
+		return int64(d.bits), 0, nil
 	}
-	return uint16(d.value)
+	return 0, d.bits, nil
 }
 
-// Uint32 returns the internal representation of this type as an uint32
-func (d *LudwiegDynInt) Uint32() uint32 {
-	if !d.hasValue {
-		return 0
+func (d *LudwiegDynInt) unsigned(to string, max uint64) (uint64, error) {
+	neg, pos, err := d.integer(to)
+	if err != nil {
+		return 0, err
 	}
-	return uint32(d.value)
+	if neg < 0 || pos > max {
+		return 0, d.overflowError(to)
+	}
+	return pos, nil
 }
 
-// Uint64 returns the internal representation of this type as an uint64
-func (d *LudwiegDynInt) Uint64() uint64 {
-	if !d.hasValue {
-		return 0
+func (d *LudwiegDynInt) signed(to string, min, max int64) (int64, error) {
+	neg, pos, err := d.integer(to)
+	if err != nil {
+		return 0, err
+	}
+	if neg < min || pos > uint64(max) {
+		return 0, d.overflowError(to)
 	}
-	return uint64(d.value)
+	if neg < 0 {
+		return neg, nil
+	}
+	return int64(pos), nil
+}
+
+// Uint8 returns the internal representation of this type as an uint8
+func (d *LudwiegDynInt) Uint8() (uint8, error) {
+	v, err := d.unsigned("uint8", math.MaxUint8)
+	return uint8(v), err
+}
+
+// Uint16 returns the internal representation of this type as an uint16
+func (d *LudwiegDynInt) Uint16() (uint16, error) {
+	v, err := d.unsigned("uint16", math.MaxUint16)
+	return uint16(v), err
+}
+
+// Uint32 returns the internal representation of this type as an uint32
+func (d *LudwiegDynInt) Uint32() (uint32, error) {
+	v, err := d.unsigned("uint32", math.MaxUint32)
+	return uint32(v), err
+}
+
+// Uint64 returns the internal representation of this type as an uint64
+func (d *LudwiegDynInt) Uint64() (uint64, error) {
+	return d.unsigned("uint64", math.MaxUint64)
 }
 
 // Int8 returns the internal representation of this type as an int8
-func (d *LudwiegDynInt) Int8() int8 {
-	if !d.hasValue {
-		return 0
-	}
-	return int8(d.value)
+func (d *LudwiegDynInt) Int8() (int8, error) {
+	v, err := d.signed("int8", math.MinInt8, math.MaxInt8)
+	return int8(v), err
 }
 
 // Int16 returns the internal representation of this type as an int16
-func (d *LudwiegDynInt) Int16() int16 {
-	if !d.hasValue {
-		return 0
-	}
-	return int16(d.value)
+func (d *LudwiegDynInt) Int16() (int16, error) {
+	v, err := d.signed("int16", math.MinInt16, math.MaxInt16)
+	return int16(v), err
 }
 
 // Int32 returns the internal representation of this type as an int32
-func (d *LudwiegDynInt) Int32() int32 {
-	if !d.hasValue {
-		return 0
-	}
-	return int32(d.value)
+func (d *LudwiegDynInt) Int32() (int32, error) {
+	v, err := d.signed("int32", math.MinInt32, math.MaxInt32)
+	return int32(v), err
 }
 
 // Int64 returns the internal representation of this type as an int64
-func (d *LudwiegDynInt) Int64() int64 {
-	if !d.hasValue {
-		return 0
-	}
-	return int64(d.value)
+func (d *LudwiegDynInt) Int64() (int64, error) {
+	return d.signed("int64", math.MinInt64, math.MaxInt64)
 }
 
-// Float32 returns the internal representation of this type as a float32
-func (d *LudwiegDynInt) Float32() float32 {
-	if !d.hasValue {
-		return 0
-	}
-	return float32(d.value)
+// Int returns the internal representation of this type as a int
+func (d *LudwiegDynInt) Int() (int, error) {
+	v, err := d.signed("int", math.MinInt, math.MaxInt)
+	return int(v), err
 }
 
-// Float64 returns the internal representation of this type as a float64
-func (d *LudwiegDynInt) Float64() float64 {
-	if !d.hasValue {
-		return 0
+// Float64 returns the internal representation of this type as a float64.
+// Integers larger than 2^53 may be rounded to the nearest float64 value.
+func (d *LudwiegDynInt) Float64() (float64, error) {
+	switch {
+	case !d.hasValue:
+		return 0, nil
+	case d.isFloat():
+		return math.Float64frombits(d.bits), nil
+	case d.isSigned():
+		return float64(int64(d.bits)), nil
 	}
-	return float64(d.value)
+	return float64(d.bits), nil
 }
 
-// Int returns the internal representation of this type as a int
-func (d *LudwiegDynInt) Int() int {
-	if !d.hasValue {
-		return 0
+// Float32 returns the internal representation of this type as a float32.
+// Values may be rounded to the nearest float32 value.
+func (d *LudwiegDynInt) Float32() (float32, error) {
+	v, _ := d.Float64()
+	if math.Abs(v) > math.MaxFloat32 && !math.IsInf(v, 0) {
+		return 0, d.overflowError("float32")
 	}
-	return int(d.value)
+	return float32(v), nil
 }
@@ -0,0 +1,47 @@
+package impl
+
+import (
+	"bytes"
+)
+
+// LudwiegFloat is used to safely represent a nullable float32 value
+type LudwiegFloat struct {
+	HasValue bool
+	Value    float32
+}
+
+// Float returns a safe nullable Float value
+func Float(v float32) *LudwiegFloat {
+	return &LudwiegFloat{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+func serializeFloat(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegFloat); ok {
+		writeFloat(v.Value, b)
+	} else {
+		return illegalSetterValueError("float32")
+	}
+	return nil
+}
+
+func decodeFloat(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegFloat{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeFloat, b, offset, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LudwiegFloat{
+		HasValue: true,
+		Value:    readFloat(tmpBuf),
+	}, nil
+}
@@ -0,0 +1,45 @@
+package impl
+
+import "bytes"
+
+// LudwiegInt16 is used to safely represent a nullable int16 value
+type LudwiegInt16 struct {
+	HasValue bool
+	Value    int16
+}
+
+// Int16 returns a safe nullable Int16 value
+func Int16(v int16) *LudwiegInt16 {
+	return &LudwiegInt16{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+func serializeInt16(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegInt16); ok {
+		writeUint16(uint16(v.Value), b)
+	} else {
+		return illegalSetterValueError("int16")
+	}
+	return nil
+}
+
+func decodeInt16(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegInt16{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeInt16, b, offset, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LudwiegInt16{
+		HasValue: true,
+		Value:    int16(readUint16(tmpBuf)),
+	}, nil
+}
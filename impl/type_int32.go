@@ -0,0 +1,45 @@
+package impl
+
+import "bytes"
+
+// LudwiegInt32 is used to safely represent a nullable int32 value
+type LudwiegInt32 struct {
+	HasValue bool
+	Value    int32
+}
+
+// Int32 returns a safe nullable Int32 value
+func Int32(v int32) *LudwiegInt32 {
+	return &LudwiegInt32{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+func serializeInt32(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegInt32); ok {
+		writeUint32(uint32(v.Value), b)
+	} else {
+		return illegalSetterValueError("int32")
+	}
+	return nil
+}
+
+func decodeInt32(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegInt32{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeInt32, b, offset, 4)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LudwiegInt32{
+		HasValue: true,
+		Value:    int32(readUint32(tmpBuf)),
+	}, nil
+}
@@ -0,0 +1,45 @@
+package impl
+
+import "bytes"
+
+// LudwiegInt64 is used to safely represent a nullable int64 value
+type LudwiegInt64 struct {
+	HasValue bool
+	Value    int64
+}
+
+// Int64 returns a safe nullable Int64 value
+func Int64(v int64) *LudwiegInt64 {
+	return &LudwiegInt64{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+func serializeInt64(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegInt64); ok {
+		writeUint64(uint64(v.Value), b)
+	} else {
+		return illegalSetterValueError("int64")
+	}
+	return nil
+}
+
+func decodeInt64(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegInt64{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeInt64, b, offset, 8)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LudwiegInt64{
+		HasValue: true,
+		Value:    int64(readUint64(tmpBuf)),
+	}, nil
+}
@@ -0,0 +1,45 @@
+package impl
+
+import "bytes"
+
+// LudwiegInt8 is used to safely represent a nullable int8 value
+type LudwiegInt8 struct {
+	HasValue bool
+	Value    int8
+}
+
+// Int8 returns a safe nullable Int8 value
+func Int8(v int8) *LudwiegInt8 {
+	return &LudwiegInt8{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+func serializeInt8(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegInt8); ok {
+		b.WriteByte(byte(v.Value))
+	} else {
+		return illegalSetterValueError("int8")
+	}
+	return nil
+}
+
+func decodeInt8(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegInt8{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeInt8, b, offset, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LudwiegInt8{
+		HasValue: true,
+		Value:    int8(tmpBuf[0]),
+	}, nil
+}
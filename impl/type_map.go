@@ -0,0 +1,215 @@
+package impl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+/* Similar to arrays, maps are represented by native Go maps. Keys are
+represented by native values (such as string or uint32), while values are
+represented just like array items. */
+
+// mapKeyTypes retains protocol types that may be used as map keys.
+var mapKeyTypes = map[ProtocolType]bool{
+	TypeUint8: true, TypeUint16: true, TypeUint32: true, TypeUint64: true,
+	TypeInt8: true, TypeInt16: true, TypeInt32: true, TypeInt64: true,
+	TypeString: true, TypeBool: true, TypeUUID: true,
+}
+
+// mapKeyGoType returns the native Go type used to represent keys of type t,
+// which is the type of the Value field of its nullable representation.
+func mapKeyGoType(t ProtocolType) (reflect.Type, bool) {
+	if !mapKeyTypes[t] {
+		return nil, false
+	}
+	field, ok := protocolGoTypes[t].Elem().FieldByName("Value")
+	if !ok {
+		return nil, false
+	}
+	return field.Type, true
+}
+
+func serializeMap(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	keyType := c.annotation.MapKeyType
+	valueType := c.annotation.MapValueType
+	keyGoType, ok := mapKeyGoType(keyType)
+	if !ok {
+		return fmt.Errorf("invalid map key type %s", keyType)
+	}
+	if valueType == TypeUnknown {
+		return fmt.Errorf("invalid map value type %s", valueType)
+	}
+
+	keys := c.value.MapKeys()
+	sortMapKeys(keys)
+
+	var mapBuf bytes.Buffer
+	keyAnnotation := &LudwiegTypeAnnotation{Type: keyType}
+	keyMeta := keyAnnotation.metaProtocolByte()
+	valueAnnotation := &LudwiegTypeAnnotation{Type: valueType}
+	valueMeta := valueAnnotation.metaProtocolByte()
+	for _, key := range keys {
+		// Keys are wrapped in their nullable representation, so they can be
+		// handled by their regular serializer.
+		wrappedKey := reflect.New(protocolGoTypes[keyType].Elem())
+		wrappedKey.Elem().FieldByName("HasValue").SetBool(true)
+		wrappedKey.Elem().FieldByName("Value").Set(key.Convert(keyGoType))
+		err := serialize(&mapBuf, &serializationCandidate{&wrappedKey, keyAnnotation, keyMeta, false, false, c.registry})
+		if err != nil {
+			return err
+		}
+
+		value := c.value.MapIndex(key)
+		if isNil(&value) {
+			return fmt.Errorf("map values cannot be nil (key %v)", key.Interface())
+		}
+		err = serialize(&mapBuf, &serializationCandidate{&value, valueAnnotation, valueMeta, false, false, c.registry})
+		if err != nil {
+			return err
+		}
+	}
+
+	writeSize(uint64(mapBuf.Len()), b)
+	b.WriteByte(keyMeta.byte())
+	b.WriteByte(valueMeta.byte())
+	writeSize(uint64(len(keys)), b)
+	b.Write(mapBuf.Bytes())
+	return nil
+}
+
+// sortMapKeys sorts native map keys, ensuring maps are always serialised
+// into the same sequence of bytes.
+func sortMapKeys(keys []reflect.Value) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return a.Uint() < b.Uint()
+		case reflect.Bool:
+			return !a.Bool() && b.Bool()
+		case reflect.Array:
+			// UUIDs, the only keys represented by arrays.
+			x, y := a.Interface().(UUIDValue), b.Interface().(UUIDValue)
+			return bytes.Compare(x[:], y[:]) < 0
+		}
+		return a.String() < b.String()
+	})
+}
+
+func decodeMap(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		// Empty maps are left with the initial value of their fields.
+		return nil, nil
+	}
+
+	// Maps follow the same layout as arrays, including both key and value
+	// types:
+	// 1. Size of the buffer containing map data
+	size, err := ctx.readSize(TypeMap, b, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Types of keys and values
+	rawTypes, err := ctx.read(TypeMap, b, offset, 2)
+	if err != nil {
+		return nil, err
+	}
+	keyType := metaTypeFromByte(rawTypes[0])
+	valueType := metaTypeFromByte(rawTypes[1])
+
+	// 3. How many entries the map contains
+	entries, err := ctx.readSize(TypeMap, b, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimit("map length", entries, ctx.opts.MaxArrayItems); err != nil {
+		return nil, err
+	}
+
+	start := *offset
+	tmpBuffer, err := ctx.read(TypeMap, b, offset, size)
+	if err != nil {
+		return nil, err
+	}
+
+	if !mapKeyTypes[keyType.ManagedType] {
+		return nil, fmt.Errorf("%w %#v", ErrUnknownType, keyType.Type)
+	}
+	keyDecoder := registeredTypeDecoder[keyType.ManagedType]
+	valueDecoder, ok := registeredTypeDecoder[valueType.ManagedType]
+	if !ok {
+		return nil, fmt.Errorf("%w %#v", ErrUnknownType, valueType.Type)
+	}
+	if err := checkItemType(keyType); err != nil {
+		return nil, err
+	}
+	if err := checkItemType(valueType); err != nil {
+		return nil, err
+	}
+
+	ctx.base += start
+	defer func() { ctx.base -= start }()
+
+	// Decoded maps use native keys, and decoded values, just like arrays.
+	result := make(map[interface{}]interface{}, int(capacityFor(entries, tmpBuffer)))
+	itemType, itemAnnotation := ctx.item()
+	innerOffset := 0
+
+	for i := 0; innerOffset < len(tmpBuffer); i++ {
+		if err := checkLimit("map length", uint64(i+1), ctx.opts.MaxArrayItems); err != nil {
+			return nil, err
+		}
+		err := ctx.push(fmt.Sprintf("[%d]", i), itemType, itemAnnotation)
+		if err != nil {
+			err = ctx.error(TypeMap, innerOffset, err)
+		} else {
+			err = decodeMapEntry(ctx, keyDecoder, keyType, valueDecoder, valueType, tmpBuffer, &innerOffset, result)
+		}
+		ctx.pop()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if uint64(len(result)) != entries {
+		return nil, fmt.Errorf("map declares %d entries, found %d", entries, len(result))
+	}
+
+	return result, nil
+}
+
+// decodeMapEntry decodes a single key and value from b, adding them to result.
+// Duplicate keys are rejected, as encoders never produce them.
+func decodeMapEntry(ctx *decodeContext, keyDecoder typeDecoderFunc, keyType metaProtocolByte, valueDecoder typeDecoderFunc, valueType metaProtocolByte, b []byte, offset *int, result map[interface{}]interface{}) error {
+	itemOffset := *offset
+	key, err := keyDecoder(ctx, keyType, b, offset)
+	if err == nil {
+		err = checkItemProgress(itemOffset, *offset)
+	}
+	if err != nil {
+		return ctx.error(keyType.ManagedType, itemOffset, err)
+	}
+	nativeKey := reflect.ValueOf(key).Elem().FieldByName("Value").Interface()
+	if _, ok := result[nativeKey]; ok {
+		return ctx.error(keyType.ManagedType, itemOffset, fmt.Errorf("duplicate map key %v", nativeKey))
+	}
+
+	itemOffset = *offset
+	value, err := valueDecoder(ctx, valueType, b, offset)
+	if err == nil {
+		err = checkItemProgress(itemOffset, *offset)
+	}
+	if err != nil {
+		return ctx.error(valueType.ManagedType, itemOffset, err)
+	}
+	result[nativeKey] = value
+	return nil
+}
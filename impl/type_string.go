@@ -30,13 +30,21 @@ func serializeString(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeString(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeString(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegString{}, nil
 	}
-	size := int(readSize(offset, b))
-	tmpBuf := b[*offset : *offset+size]
-	incrSize(offset, size)
+	size, err := ctx.readSize(TypeString, b, offset)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkLimit("string length", size, ctx.opts.MaxStringLen); err != nil {
+		return nil, err
+	}
+	tmpBuf, err := ctx.read(TypeString, b, offset, size)
+	if err != nil {
+		return nil, err
+	}
 
 	return &LudwiegString{
 		HasValue: true,
@@ -13,6 +13,22 @@ func serializeStruct(c *serializationCandidate, b *bytes.Buffer) error {
 
 	reflectValue := c.value
 
+	var internalBuffer bytes.Buffer
+
+	// Types implementing LudwiegMarshaler serialize their own fields, unless
+	// they are tagged.
+	if m, ok := marshalerFor(reflectValue); ok {
+		if plan, err := planFor(reflectValue.Type()); err != nil || plan.tags == nil {
+			if err := m.MarshalLudwieg(&Writer{buf: &internalBuffer, registry: c.registry}); err != nil {
+				return err
+			}
+			if err == nil {
+				writeUnknownFields(plan, reflect.Indirect(*reflectValue), &internalBuffer)
+			}
+			return writeStructBuffer(c, &internalBuffer, b)
+		}
+	}
+
 	// Here we need to forcefully coerce a ptr into its direct value,
 	// since this is used by common serializers, and the main serializer
 	// entrypoint.
@@ -21,48 +37,88 @@ func serializeStruct(c *serializationCandidate, b *bytes.Buffer) error {
 		reflectValue = &nv
 	}
 
-	// At this point, our value must be serializable. Otherwise, panic.
-	serializableType := reflect.TypeOf((*Serializable)(nil)).Elem()
-	if !reflectValue.Type().Implements(serializableType) {
+	// At this point, our value must be serializable. Its plan retains its
+	// metadata, extracted only once for each type.
+	if reflectValue.Kind() != reflect.Struct {
 		return fmt.Errorf("illegal attempt to serialize a non-serializable type %#v", reflectValue)
 	}
+	plan, err := planFor(reflectValue.Type())
+	if err != nil {
+		return fmt.Errorf("illegal attempt to serialize a non-serializable type %#v: %s", reflectValue, err)
+	}
 
-	// Here we can safely convert it to a Serializable and extract metadata
-	coercedSerializable := reflectValue.Convert(serializableType).Interface().(Serializable)
-	typeMeta := coercedSerializable.LudwiegMeta()
-
-	var internalBuffer bytes.Buffer
+	for i := range plan.fields {
+		fieldPlan := &plan.fields[i]
+		fieldValue := reflectValue.Field(fieldPlan.index)
+		// serialize may flag meta as empty, so each field uses its own copy.
+		fieldMeta := fieldPlan.meta
 
-	baseObjType := reflectValue.Type()
-	for i := 0; i < baseObjType.NumField(); i++ {
-		fieldValue := reflectValue.Field(i)
-		fieldAnnotation := typeMeta[i]
+		if plan.tags != nil {
+			// Fields of tagged structs are preceded by their field number.
+			internalBuffer.WriteByte(byte(TypeTag))
+			writeUint16(fieldPlan.annotation.Tag, &internalBuffer)
+		}
 
-		err := serialize(&internalBuffer, &serializationCandidate{&fieldValue, &fieldAnnotation, fieldAnnotation.metaProtocolByte(), true, false})
+		err := serialize(&internalBuffer, &serializationCandidate{&fieldValue, &fieldPlan.annotation, &fieldMeta, true, false, c.registry})
 		if err != nil {
 			return err
 		}
 	}
 
+	writeUnknownFields(plan, *reflectValue, &internalBuffer)
+
+	return writeStructBuffer(c, &internalBuffer, b)
+}
+
+// writeStructBuffer writes serialized struct fields into b, prefixed by their
+// size, unless the struct is the root value.
+func writeStructBuffer(c *serializationCandidate, fields *bytes.Buffer, b *bytes.Buffer) error {
 	if !c.isRoot {
-		writeSize(uint64(internalBuffer.Len()), b)
+		writeSize(uint64(fields.Len()), b)
 	}
-	b.Write(internalBuffer.Bytes())
+	b.Write(fields.Bytes())
 	return nil
 }
 
-func decodeStruct(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+// writeUnknownFields writes back fields unknown to the struct v, as they were
+// received, in case it retains them.
+func writeUnknownFields(plan *structPlan, v reflect.Value, b *bytes.Buffer) {
+	if plan.unknown >= 0 {
+		b.Write(v.Field(plan.unknown).Bytes())
+	}
+}
+
+// marshalerFor returns the LudwiegMarshaler implemented by the provided value,
+// either through a value or a pointer receiver.
+func marshalerFor(v *reflect.Value) (LudwiegMarshaler, bool) {
+	if m, ok := v.Interface().(LudwiegMarshaler); ok {
+		return m, true
+	}
+	if v.Kind() != reflect.Ptr && v.CanAddr() {
+		m, ok := v.Addr().Interface().(LudwiegMarshaler)
+		return m, ok
+	}
+	return nil, false
+}
+
+func decodeStruct(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		// Empty structs are handled as nil pointers
 		return nil, nil
 	}
 
-	strSize := int(readSize(offset, b))
-	tmpBuffer := b[*offset : *offset+strSize]
-	incrSize(offset, strSize)
+	size, err := ctx.readSize(TypeStruct, b, offset)
+	if err != nil {
+		return nil, err
+	}
+	start := *offset
+	tmpBuffer, err := ctx.read(TypeStruct, b, offset, size)
+	if err != nil {
+		return nil, err
+	}
 
 	// Also, struct values are returned as an array of possible fields and other
 	// structs. The main decoder is responsible for converting to known types
 	// and such.
-	return deserialize(tmpBuffer)
+	return ctx.deserializeAt(tmpBuffer, start)
 }
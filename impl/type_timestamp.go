@@ -0,0 +1,63 @@
+package impl
+
+import (
+	"bytes"
+	"time"
+)
+
+// LudwiegTimestamp is used to safely represent a nullable time.Time value.
+// Timestamps retain nanosecond precision, and the offset of their timezone.
+// Values in UTC, or without a known offset, are decoded in UTC.
+type LudwiegTimestamp struct {
+	HasValue bool
+	Value    time.Time
+}
+
+// Timestamp returns a safe nullable Timestamp value
+func Timestamp(v time.Time) *LudwiegTimestamp {
+	return &LudwiegTimestamp{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+// Timestamps are encoded as seconds since the Unix epoch (int64), followed by
+// nanoseconds within that second (uint32) and the timezone offset in seconds
+// east of UTC (int32).
+const timestampSize = 16
+
+func serializeTimestamp(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegTimestamp); ok {
+		_, zoneOffset := v.Value.Zone()
+		writeUint64(uint64(v.Value.Unix()), b)
+		writeUint32(uint32(v.Value.Nanosecond()), b)
+		writeUint32(uint32(int32(zoneOffset)), b)
+	} else {
+		return illegalSetterValueError("time.Time")
+	}
+	return nil
+}
+
+func decodeTimestamp(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegTimestamp{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeTimestamp, b, offset, timestampSize)
+	if err != nil {
+		return nil, err
+	}
+
+	value := time.Unix(int64(readUint64(tmpBuf)), int64(readUint32(tmpBuf[8:]))).UTC()
+	if zoneOffset := int32(readUint32(tmpBuf[12:])); zoneOffset != 0 {
+		value = value.In(time.FixedZone("", int(zoneOffset)))
+	}
+
+	return &LudwiegTimestamp{
+		HasValue: true,
+		Value:    value,
+	}, nil
+}
@@ -0,0 +1,45 @@
+package impl
+
+import "bytes"
+
+// LudwiegUint16 is used to safely represent a nullable uint16 value
+type LudwiegUint16 struct {
+	HasValue bool
+	Value    uint16
+}
+
+// Uint16 returns a safe nullable Uint16 value
+func Uint16(v uint16) *LudwiegUint16 {
+	return &LudwiegUint16{
+		HasValue: true,
+		Value:    v,
+	}
+}
+
+func serializeUint16(c *serializationCandidate, b *bytes.Buffer) error {
+	if c.writeType {
+		b.WriteByte(c.meta.byte())
+	}
+	rv := c.value.Interface()
+	if v, ok := rv.(*LudwiegUint16); ok {
+		writeUint16(v.Value, b)
+	} else {
+		return illegalSetterValueError("uint16")
+	}
+	return nil
+}
+
+func decodeUint16(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+	if t.Empty {
+		return &LudwiegUint16{}, nil
+	}
+	tmpBuf, err := ctx.read(TypeUint16, b, offset, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LudwiegUint16{
+		HasValue: true,
+		Value:    readUint16(tmpBuf),
+	}, nil
+}
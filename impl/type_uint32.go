@@ -29,12 +29,14 @@ func serializeUint32(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeUint32(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeUint32(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegUint32{}, nil
 	}
-	tmpBuf := b[*offset : *offset+4]
-	incrSize(offset, 4)
+	tmpBuf, err := ctx.read(TypeUint32, b, offset, 4)
+	if err != nil {
+		return nil, err
+	}
 
 	return &LudwiegUint32{
 		HasValue: true,
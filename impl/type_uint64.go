@@ -29,12 +29,14 @@ func serializeUint64(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeUint64(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeUint64(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegUint64{}, nil
 	}
-	tmpBuf := b[*offset : *offset+8]
-	incrSize(offset, 8)
+	tmpBuf, err := ctx.read(TypeUint64, b, offset, 8)
+	if err != nil {
+		return nil, err
+	}
 
 	return &LudwiegUint64{
 		HasValue: true,
@@ -31,12 +31,16 @@ func serializeUint8(c *serializationCandidate, b *bytes.Buffer) error {
 	return nil
 }
 
-func decodeUint8(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeUint8(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegUint8{}, nil
 	}
+	tmpBuf, err := ctx.read(TypeUint8, b, offset, 1)
+	if err != nil {
+		return nil, err
+	}
 	return &LudwiegUint8{
 		HasValue: true,
-		Value:    b[incrSize(offset, 1)],
+		Value:    tmpBuf[0],
 	}, nil
 }
@@ -11,9 +11,15 @@ type LudwiegUnknown struct {
 	Value    []byte
 }
 
-func decodeUnknown(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+// LudwiegUnknownFields retains fields unknown to the struct holding it, such as
+// fields added by newer revisions of its package, in their encoded form.
+// Structs may declare a single field of this type, which is not described by
+// LudwiegMeta. Retained fields are written back when the struct is serialised,
+// allowing intermediate services to forward them unchanged.
+type LudwiegUnknownFields []byte
+
+func decodeUnknown(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
-		incr(offset)
 		return &LudwiegUnknown{
 			HasValue: false,
 		}, nil
@@ -22,14 +28,18 @@ func decodeUnknown(t metaProtocolByte, b []byte, offset *int) (interface{}, erro
 		return nil, fmt.Errorf("unknown type with no prefixed lenght cannot be decoded")
 	}
 
-	typeSize := readSize(offset, b)
-
-	result := &LudwiegUnknown{
-		HasValue: true,
-		Value:    b[*offset:typeSize],
+	typeSize, err := ctx.readSize(TypeUnknown, b, offset)
+	if err != nil {
+		return nil, err
 	}
 
-	incrSize(offset, int(typeSize))
+	value, err := ctx.read(TypeUnknown, b, offset, typeSize)
+	if err != nil {
+		return nil, err
+	}
 
-	return result, nil
+	return &LudwiegUnknown{
+		HasValue: true,
+		Value:    value,
+	}, nil
 }
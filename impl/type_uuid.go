@@ -2,19 +2,81 @@ package impl
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
+	"io"
 )
 
+// UUIDValue represents the 16 bytes of an UUID
+type UUIDValue [16]byte
+
+// String returns the canonical hyphenated representation of the UUID, such
+// as "3232ee42-c2f2-4baf-8413-18335b4d5640"
+func (u UUIDValue) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:])
+}
+
+// ParseUUID parses an UUID represented by 32 hexadecimal digits, either in
+// its canonical hyphenated form or without hyphens.
+func ParseUUID(s string) (UUIDValue, error) {
+	var u UUIDValue
+	var digits [32]byte
+	switch len(s) {
+	case 32:
+		copy(digits[:], s)
+	case 36:
+		if s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+			return u, fmt.Errorf("invalid UUID %q", s)
+		}
+		n := copy(digits[:], s[0:8])
+		n += copy(digits[n:], s[9:13])
+		n += copy(digits[n:], s[14:18])
+		n += copy(digits[n:], s[19:23])
+		copy(digits[n:], s[24:])
+	default:
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	if _, err := hex.Decode(u[:], digits[:]); err != nil {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+	return u, nil
+}
+
+// NewUUID returns a random (version 4) UUID
+func NewUUID() (UUIDValue, error) {
+	var u UUIDValue
+	if _, err := io.ReadFull(rand.Reader, u[:]); err != nil {
+		return u, err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u, nil
+}
+
 // LudwiegUUID is used to safely represent a nullable UUID value
-type LudwiegUUID LudwiegString
+type LudwiegUUID struct {
+	HasValue bool
+	Value    UUIDValue
+}
 
-// UUID returns a safe nullable UUID value
-func UUID(v string) *LudwiegUUID {
-	uuid := LudwiegUUID(*String(v))
-	return &uuid
+// UUID returns a safe nullable UUID value. UUIDs represented by strings may
+// be obtained through ParseUUID.
+func UUID(v UUIDValue) *LudwiegUUID {
+	return &LudwiegUUID{
+		HasValue: true,
+		Value:    v,
+	}
 }
 
 func serializeUUID(c *serializationCandidate, b *bytes.Buffer) error {
@@ -23,57 +85,33 @@ func serializeUUID(c *serializationCandidate, b *bytes.Buffer) error {
 	}
 
 	rv := c.value.Interface()
-	var val string
 	switch v := rv.(type) {
-	case *LudwiegString:
-		val = v.Value
 	case *LudwiegUUID:
-		val = v.Value
-	default:
-		return illegalSetterValueError("uuid")
-	}
-
-	reg := regexp.MustCompile(`[0-9a-f]+`)
-	val = strings.ToLower(strings.Replace(val, "-", "", -1))
-	if len(val) != 32 || !reg.Match([]byte(val)) {
-		return fmt.Errorf("invalid value %s for UUID field", val)
-	}
-
-	data := make([]byte, 16)
-	dataCursor := 0
-	for i := 0; i < 32; i += 2 {
-		end := i + 2
-		i, err := strconv.ParseUint(val[i:end], 16, 8)
+		b.Write(v.Value[:])
+	case *LudwiegString:
+		u, err := ParseUUID(v.Value)
 		if err != nil {
-			// Should not happen, since we're validating conformity and
-			// length one step behind, but let's leave this here as a sanity
-			// check.
-			return fmt.Errorf("invalid value %s for UUID field", val)
+			return fmt.Errorf("invalid value %s for UUID field", v.Value)
 		}
-		data[dataCursor] = byte(i)
-		dataCursor++
+		b.Write(u[:])
+	default:
+		return illegalSetterValueError("uuid")
 	}
 
-	b.Write(data)
-
 	return nil
 }
 
-func decodeUUID(t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
+func decodeUUID(ctx *decodeContext, t metaProtocolByte, b []byte, offset *int) (interface{}, error) {
 	if t.Empty {
 		return &LudwiegUUID{}, nil
 	}
 
-	tmpBuf := b[*offset : *offset+16]
-	incrSize(offset, 16)
-
-	value := make([]string, 16)
-	for i, b := range tmpBuf {
-		value[i] = fmt.Sprintf("%x", b)
+	tmpBuf, err := ctx.read(TypeUUID, b, offset, 16)
+	if err != nil {
+		return nil, err
 	}
 
-	return &LudwiegUUID{
-		HasValue: true,
-		Value:    strings.Join(value, ""),
-	}, nil
+	result := &LudwiegUUID{HasValue: true}
+	copy(result.Value[:], tmpBuf)
+	return result, nil
 }
@@ -1,7 +1,11 @@
 package impl
 
+import "fmt"
+
 var magicBytes = []byte{0x27, 0x24, 0x50}
 
+const protocolVersion byte = 0x01
+
 const (
 	hasPrefixedLengthBit byte = 0x1
 	isEmptyBit                = 0x2
@@ -16,47 +20,113 @@ const (
 	TypeUnknown ProtocolType = 0x00
 
 	// TypeUint8 represents an Uint8 type
-	TypeUint8 = 0x01 << 2
+	TypeUint8 ProtocolType = 0x01 << 2
 
 	// TypeUint32 represents an Uint32 type
-	TypeUint32 = 0x02 << 2
+	TypeUint32 ProtocolType = 0x02 << 2
 
 	// TypeUint64 represents an Uint64 type
-	TypeUint64 = 0x03 << 2
+	TypeUint64 ProtocolType = 0x03 << 2
 
 	// TypeDouble represents a Float64 type
-	TypeDouble = 0x04 << 2
+	TypeDouble ProtocolType = 0x04 << 2
 
 	// TypeString represents a String type
-	TypeString = (0x05 << 2) | 0x1
+	TypeString ProtocolType = (0x05 << 2) | 0x1
 
 	// TypeBlob represents a Blob type
-	TypeBlob = (0x06 << 2) | 0x1
+	TypeBlob ProtocolType = (0x06 << 2) | 0x1
 
 	// TypeBool represents a Bool type
-	TypeBool = 0x07 << 2
+	TypeBool ProtocolType = 0x07 << 2
 
 	// TypeArray represents an Array type
-	TypeArray = (0x08 << 2) | 0x1
+	TypeArray ProtocolType = (0x08 << 2) | 0x1
 
 	// TypeUUID represents an UUID type
-	TypeUUID = 0x09 << 2
+	TypeUUID ProtocolType = 0x09 << 2
 
 	// TypeAny represents any type Ludwieg is capable of handling
-	TypeAny = (0x0A << 2) | 0x1
+	TypeAny ProtocolType = (0x0A << 2) | 0x1
 
 	// TypeStruct is used internally to identify fields containing structs
-	TypeStruct = (0x0B << 2) | 0x1
+	TypeStruct ProtocolType = (0x0B << 2) | 0x1
 
 	// TypeDynInt represents an Integer value that may assume several sizes and
 	// precisions (byte, uint16, uint32, uint64, float32, float64)
-	TypeDynInt = 0x0C << 2
+	TypeDynInt ProtocolType = 0x0C << 2
+
+	// TypeUint16 represents an Uint16 type
+	TypeUint16 ProtocolType = 0x0D << 2
+
+	// TypeInt8 represents an Int8 type
+	TypeInt8 ProtocolType = 0x0E << 2
+
+	// TypeInt16 represents an Int16 type
+	TypeInt16 ProtocolType = 0x0F << 2
+
+	// TypeInt32 represents an Int32 type
+	TypeInt32 ProtocolType = 0x10 << 2
+
+	// TypeInt64 represents an Int64 type
+	TypeInt64 ProtocolType = 0x11 << 2
+
+	// TypeFloat represents a Float32 type
+	TypeFloat ProtocolType = 0x12 << 2
+
+	// TypeTimestamp represents a point in time, with nanosecond precision and
+	// its timezone offset
+	TypeTimestamp ProtocolType = 0x13 << 2
+
+	// TypeDuration represents an elapsed time, with nanosecond precision
+	TypeDuration ProtocolType = 0x14 << 2
+
+	// TypeMap represents a Map type
+	TypeMap ProtocolType = (0x15 << 2) | 0x1
+
+	// TypeTag precedes each field of tagged structs, retaining the field
+	// number of the value that follows it
+	TypeTag ProtocolType = 0x16 << 2
 )
 
+var protocolTypeNames = map[ProtocolType]string{
+	TypeUnknown:   "Unknown",
+	TypeUint8:     "Uint8",
+	TypeUint32:    "Uint32",
+	TypeUint64:    "Uint64",
+	TypeDouble:    "Double",
+	TypeString:    "String",
+	TypeBlob:      "Blob",
+	TypeBool:      "Bool",
+	TypeArray:     "Array",
+	TypeUUID:      "UUID",
+	TypeAny:       "Any",
+	TypeStruct:    "Struct",
+	TypeDynInt:    "DynInt",
+	TypeUint16:    "Uint16",
+	TypeInt8:      "Int8",
+	TypeInt16:     "Int16",
+	TypeInt32:     "Int32",
+	TypeInt64:     "Int64",
+	TypeFloat:     "Float",
+	TypeTimestamp: "Timestamp",
+	TypeDuration:  "Duration",
+	TypeMap:       "Map",
+	TypeTag:       "Tag",
+}
+
+func (t ProtocolType) String() string {
+	if name, ok := protocolTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ProtocolType(%#x)", byte(t))
+}
+
 var knownTypes = []ProtocolType{
 	TypeUint8, TypeUint32, TypeUint64, TypeDouble, TypeString,
 	TypeBlob, TypeBool, TypeArray, TypeUUID, TypeAny, TypeStruct,
-	TypeDynInt,
+	TypeDynInt, TypeUint16, TypeInt8, TypeInt16, TypeInt32, TypeInt64,
+	TypeFloat, TypeTimestamp, TypeDuration, TypeMap, TypeTag,
 }
 
 type lengthEncoding byte
@@ -109,3 +179,24 @@ const (
 	// DynIntValueKindFloat64 represents an 64-bit float value
 	DynIntValueKindFloat64
 )
+
+var dynIntValueKindNames = map[DynIntValueKind]string{
+	DynIntValueKindInvalid: "Invalid",
+	DynIntValueKindUint8:   "Uint8",
+	DynIntValueKindUint16:  "Uint16",
+	DynIntValueKindUint32:  "Uint32",
+	DynIntValueKindUint64:  "Uint64",
+	DynIntValueKindInt8:    "Int8",
+	DynIntValueKindInt16:   "Int16",
+	DynIntValueKindInt32:   "Int32",
+	DynIntValueKindInt64:   "Int64",
+	DynIntValueKindFloat32: "Float32",
+	DynIntValueKindFloat64: "Float64",
+}
+
+func (k DynIntValueKind) String() string {
+	if name, ok := dynIntValueKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("DynIntValueKind(%d)", byte(k))
+}
@@ -37,6 +37,14 @@ func readUint64(b []byte) uint64 {
 	return binary.LittleEndian.Uint64(b)
 }
 
+func writeFloat(v float32, b *bytes.Buffer) {
+	writeUint32(math.Float32bits(v), b)
+}
+
+func readFloat(b []byte) float32 {
+	return math.Float32frombits(readUint32(b))
+}
+
 func writeDouble(v float64, b *bytes.Buffer) {
 	bits := math.Float64bits(v)
 	buf := make([]byte, 8)
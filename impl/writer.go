@@ -0,0 +1,150 @@
+package impl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// LudwiegMarshaler may be implemented by generated structures to serialize
+// their own fields without relying on reflection. Fields must be written in
+// the same order they are annotated by LudwiegMeta. Tagged structs are always
+// serialized through reflection, so their fields are written along with their
+// tags.
+type LudwiegMarshaler interface {
+	MarshalLudwieg(w *Writer) error
+}
+
+// Writer provides primitives used by LudwiegMarshaler implementations to
+// write struct fields. Each method writes a single field, along with its type
+// information.
+type Writer struct {
+	buf      *bytes.Buffer
+	registry *Registry
+}
+
+func (w *Writer) write(v interface{}, annotation LudwiegTypeAnnotation) error {
+	value := reflect.ValueOf(v)
+	meta := *annotation.metaProtocolByte()
+	if !value.IsValid() {
+		// Untyped nils are handled as any other empty value.
+		meta.Empty = true
+		w.buf.WriteByte(meta.byte())
+		return nil
+	}
+	return serialize(w.buf, &serializationCandidate{&value, &annotation, &meta, true, false, w.registry})
+}
+
+// WriteUint8 writes an Uint8 field
+func (w *Writer) WriteUint8(v *LudwiegUint8) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeUint8})
+}
+
+// WriteUint32 writes an Uint32 field
+func (w *Writer) WriteUint32(v *LudwiegUint32) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeUint32})
+}
+
+// WriteUint64 writes an Uint64 field
+func (w *Writer) WriteUint64(v *LudwiegUint64) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeUint64})
+}
+
+// WriteUint16 writes an Uint16 field
+func (w *Writer) WriteUint16(v *LudwiegUint16) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeUint16})
+}
+
+// WriteInt8 writes an Int8 field
+func (w *Writer) WriteInt8(v *LudwiegInt8) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeInt8})
+}
+
+// WriteInt16 writes an Int16 field
+func (w *Writer) WriteInt16(v *LudwiegInt16) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeInt16})
+}
+
+// WriteInt32 writes an Int32 field
+func (w *Writer) WriteInt32(v *LudwiegInt32) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeInt32})
+}
+
+// WriteInt64 writes an Int64 field
+func (w *Writer) WriteInt64(v *LudwiegInt64) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeInt64})
+}
+
+// WriteFloat writes a Float field
+func (w *Writer) WriteFloat(v *LudwiegFloat) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeFloat})
+}
+
+// WriteDouble writes a Double field
+func (w *Writer) WriteDouble(v *LudwiegDouble) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeDouble})
+}
+
+// WriteString writes a String field
+func (w *Writer) WriteString(v *LudwiegString) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeString})
+}
+
+// WriteBlob writes a Blob field
+func (w *Writer) WriteBlob(v []byte) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeBlob})
+}
+
+// WriteBool writes a Bool field
+func (w *Writer) WriteBool(v *LudwiegBool) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeBool})
+}
+
+// WriteUUID writes an UUID field
+func (w *Writer) WriteUUID(v *LudwiegUUID) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeUUID})
+}
+
+// WriteTimestamp writes a Timestamp field
+func (w *Writer) WriteTimestamp(v *LudwiegTimestamp) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeTimestamp})
+}
+
+// WriteDuration writes a Duration field
+func (w *Writer) WriteDuration(v *LudwiegDuration) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeDuration})
+}
+
+// WriteAny writes an Any field
+func (w *Writer) WriteAny(v *LudwiegAny) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeAny})
+}
+
+// WriteDynInt writes a DynInt field
+func (w *Writer) WriteDynInt(v *LudwiegDynInt) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeDynInt})
+}
+
+// WriteStruct writes a Struct field. v is expected to be a pointer to a
+// Serializable structure, and may be nil.
+func (w *Writer) WriteStruct(v Serializable) error {
+	return w.write(v, LudwiegTypeAnnotation{Type: TypeStruct})
+}
+
+// WriteMap writes a Map field. v is expected to be a map, described by the
+// provided annotation.
+func (w *Writer) WriteMap(v interface{}, annotation LudwiegTypeAnnotation) error {
+	if annotation.Type != TypeMap {
+		return fmt.Errorf("cannot write %s annotation as a map", annotation.Type)
+	}
+	return w.write(v, annotation)
+}
+
+// WriteArray writes an Array field. v is expected to be a slice, described by
+// the provided annotation.
+func (w *Writer) WriteArray(v interface{}, annotation LudwiegTypeAnnotation) error {
+	if annotation.Type != TypeArray {
+		return fmt.Errorf("cannot write %s annotation as an array", annotation.Type)
+	}
+	return w.write(v, annotation)
+}
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -9,7 +19,8 @@ import (
 )
 
 func init() {
-	impl.RegisterPackages(Test{}, Fieldless{})
+	impl.RegisterPackages(Test{}, Fieldless{}, TestSubOtherPackage{}, BlobPackage{}, MarshaledPackage{}, IntegersPackage{}, FloatPackage{}, TimePackage{}, MapPackage{}, FixedArrayPackage{}, MatrixPackage{}, OptionalPackage{}, TaggedPackage{}, RelayPackage{}, TaggedRelayPackage{}, TaggedMarshaledPackage{}, AnyTestStruct{})
+	impl.RegisterAnyType(0x0101, AnyPayload{})
 }
 
 type Fieldless struct{}
@@ -84,6 +95,28 @@ func (t TestSubOther) LudwiegMeta() []impl.LudwiegTypeAnnotation {
 	}
 }
 
+type TestSubOtherPackage struct {
+	FieldL *impl.LudwiegString
+}
+
+func (t TestSubOtherPackage) LudwiegID() byte { return 0x04 }
+func (t TestSubOtherPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString},
+	}
+}
+
+type BlobPackage struct {
+	FieldF []byte
+}
+
+func (t BlobPackage) LudwiegID() byte { return 0x05 }
+func (t BlobPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeBlob},
+	}
+}
+
 type CustomType struct {
 	FieldV *impl.LudwiegString
 }
@@ -94,6 +127,117 @@ func (t CustomType) LudwiegMeta() []impl.LudwiegTypeAnnotation {
 	}
 }
 
+// MarshaledPackage implements LudwiegMarshaler and LudwiegUnmarshaler, the
+// same way generated sources do.
+type MarshaledPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB *impl.LudwiegUint32
+	FieldC [](*CustomType)
+	FieldD *TestSubOther
+}
+
+var unmarshaledPackages int
+
+func (t MarshaledPackage) LudwiegID() byte { return 0x06 }
+func (t MarshaledPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString},
+		{Type: impl.TypeUint32},
+		impl.ArrayOf(CustomType{}),
+		{Type: impl.TypeStruct},
+	}
+}
+
+func (t MarshaledPackage) MarshalLudwieg(w *impl.Writer) error {
+	if err := w.WriteString(t.FieldA); err != nil {
+		return err
+	}
+	if err := w.WriteUint32(t.FieldB); err != nil {
+		return err
+	}
+	if err := w.WriteArray(t.FieldC, impl.ArrayOf(CustomType{})); err != nil {
+		return err
+	}
+	return w.WriteStruct(t.FieldD)
+}
+
+func (t *MarshaledPackage) UnmarshalLudwieg(r *impl.Reader) (err error) {
+	unmarshaledPackages++
+	if t.FieldA, err = r.ReadString(); err != nil {
+		return err
+	}
+	if t.FieldB, err = r.ReadUint32(); err != nil {
+		return err
+	}
+	if err = r.ReadArray(&t.FieldC); err != nil {
+		return err
+	}
+	v, err := r.ReadStruct(TestSubOther{})
+	if v != nil {
+		t.FieldD = v.(*TestSubOther)
+	}
+	return err
+}
+
+// ReflectedPackage has the same layout as MarshaledPackage, and is serialized
+// through reflection.
+type ReflectedPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB *impl.LudwiegUint32
+	FieldC [](*CustomType)
+	FieldD *TestSubOther
+}
+
+func (t ReflectedPackage) LudwiegID() byte { return 0x06 }
+func (t ReflectedPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return MarshaledPackage{}.LudwiegMeta()
+}
+
+// TaggedMarshaledPackage implements LudwiegMarshaler and LudwiegUnmarshaler
+// while declaring tagged fields.
+type TaggedMarshaledPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB *impl.LudwiegUint32
+}
+
+func (t TaggedMarshaledPackage) LudwiegID() byte { return 0x14 }
+func (t TaggedMarshaledPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString, Tag: 1},
+		{Type: impl.TypeUint32, Tag: 2},
+	}
+}
+
+func (t TaggedMarshaledPackage) MarshalLudwieg(w *impl.Writer) error {
+	if err := w.WriteString(t.FieldA); err != nil {
+		return err
+	}
+	return w.WriteUint32(t.FieldB)
+}
+
+func (t *TaggedMarshaledPackage) UnmarshalLudwieg(r *impl.Reader) (err error) {
+	if t.FieldA, err = r.ReadString(); err != nil {
+		return err
+	}
+	t.FieldB, err = r.ReadUint32()
+	return err
+}
+
+// ReorderedTaggedPackage shares its ID and tags with TaggedMarshaledPackage,
+// declaring its fields in reverse order.
+type ReorderedTaggedPackage struct {
+	FieldB *impl.LudwiegUint32
+	FieldA *impl.LudwiegString
+}
+
+func (t ReorderedTaggedPackage) LudwiegID() byte { return 0x14 }
+func (t ReorderedTaggedPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeUint32, Tag: 2},
+		{Type: impl.TypeString, Tag: 1},
+	}
+}
+
 func TestEncoderDecoder(t *testing.T) {
 	obj := Test{
 		FieldA:  impl.Uint8(27),
@@ -103,7 +247,7 @@ func TestEncoderDecoder(t *testing.T) {
 		FieldE:  impl.String("String"),
 		FieldF:  []byte{0x27, 0x24, 0x50},
 		FieldG:  impl.Bool(true),
-		FieldH:  impl.UUID("3232ee42c2f24baf841318335b4d5640"),
+		FieldH:  impl.UUID(mustParseUUID("3232ee42c2f24baf841318335b4d5640")),
 		FieldY:  impl.Any(impl.String("Any field retaining a string")),
 		FieldZ:  []*impl.LudwiegString{impl.String("Robin"), impl.String("Tom")},
 		FieldZA: []*CustomType{{impl.String("hello")}, {impl.String("friend")}},
@@ -139,7 +283,7 @@ func TestEncoderDecoder(t *testing.T) {
 			assert.Equal(t, uint8(0x24), r.FieldF[1])
 			assert.Equal(t, uint8(0x50), r.FieldF[2])
 			assert.True(t, r.FieldG.Value)
-			assert.Equal(t, "3232ee42c2f24baf841318335b4d5640", r.FieldH.Value)
+			assert.Equal(t, "3232ee42-c2f2-4baf-8413-18335b4d5640", r.FieldH.Value.String())
 			av, ok := r.FieldY.Value.(*impl.LudwiegString)
 			assert.True(t, ok)
 			assert.Equal(t, "Any field retaining a string", av.Value)
@@ -153,7 +297,9 @@ func TestEncoderDecoder(t *testing.T) {
 			assert.Equal(t, "hello", r.FieldZA[0].FieldV.Value)
 			assert.Equal(t, "friend", r.FieldZA[1].FieldV.Value)
 			assert.Equal(t, impl.DynIntValueKindUint8, r.FieldJ.UnderlyingType)
-			assert.Equal(t, 27, r.FieldJ.Int())
+			j, err := r.FieldJ.Int()
+			assert.Nil(t, err)
+			assert.Equal(t, 27, j)
 			return
 		}
 	}
@@ -254,3 +400,1422 @@ func TestDeserializeAnyStruct(t *testing.T) {
 	actualResult := res.(*TestSubOther)
 	assert.Equal(t, "hello", actualResult.FieldL.Value)
 }
+
+func TestDecoder(t *testing.T) {
+	var stream bytes.Buffer
+	for i := byte(0); i < 2; i++ {
+		buf, err := impl.SerializeMessage(TestSubOtherPackage{FieldL: impl.String("hello")}, i)
+		assert.Nil(t, err)
+		stream.Write(buf.Bytes())
+	}
+
+	dec := impl.NewDecoder(iotest.OneByteReader(&stream))
+	for i := byte(0); i < 2; i++ {
+		v, meta, err := dec.Decode()
+		assert.Nil(t, err)
+		assert.Equal(t, i, meta.MessageID)
+		assert.Equal(t, "hello", v.(*TestSubOtherPackage).FieldL.Value)
+	}
+
+	_, _, err := dec.Decode()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecoderUnexpectedEOF(t *testing.T) {
+	buf, err := impl.SerializeMessage(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x01)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	dec := impl.NewDecoder(bytes.NewReader(data[:len(data)-1]))
+	_, _, err = dec.Decode()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestEncoder(t *testing.T) {
+	var stream bytes.Buffer
+	enc := impl.NewEncoder(&stream)
+	assert.Nil(t, enc.Encode(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x01))
+	assert.Nil(t, enc.Encode(TestSubOtherPackage{FieldL: impl.String("friend")}, 0x02))
+
+	buf, err := impl.SerializeMessage(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x01)
+	assert.Nil(t, err)
+	assert.Equal(t, buf.Bytes(), stream.Bytes()[:buf.Len()])
+
+	dec := impl.NewDecoder(&stream)
+	v, meta, err := dec.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x01), meta.MessageID)
+	assert.Equal(t, "hello", v.(*TestSubOtherPackage).FieldL.Value)
+	v, meta, err = dec.Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x02), meta.MessageID)
+	assert.Equal(t, "friend", v.(*TestSubOtherPackage).FieldL.Value)
+}
+
+func TestFeedBytes(t *testing.T) {
+	blob := make([]byte, 3<<20)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+
+	var stream bytes.Buffer
+	enc := impl.NewEncoder(&stream)
+	assert.Nil(t, enc.Encode(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x01))
+	assert.Nil(t, enc.Encode(BlobPackage{FieldF: blob}, 0x02))
+	assert.Nil(t, enc.Encode(TestSubOtherPackage{FieldL: impl.String("friend")}, 0x03))
+	data := stream.Bytes()
+
+	d := impl.Deserializer{}
+	var candidates []*impl.DeserializationCandidate
+	for _, chunk := range [][]byte{data[:5], data[5:20], data[20 : len(data)-3], data[len(data)-3:]} {
+		c, consumed := d.FeedBytes(chunk)
+		assert.Equal(t, len(chunk), consumed)
+		candidates = append(candidates, c...)
+	}
+
+	assert.Equal(t, 3, len(candidates))
+
+	// Trailing garbage is consumed as well, being discarded.
+	garbage := append(append([]byte{}, data...), 0xde, 0xad)
+	d = impl.Deserializer{}
+	c, consumed := d.FeedBytes(garbage)
+	assert.Equal(t, 3, len(c))
+	assert.Equal(t, len(garbage), consumed)
+	assert.Equal(t, uint64(2), d.DiscardedBytes())
+
+	v, err := candidates[0].Deserialize()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", v.(*TestSubOtherPackage).FieldL.Value)
+	v, err = candidates[1].Deserialize()
+	assert.Nil(t, err)
+	assert.Equal(t, blob, v.(*BlobPackage).FieldF)
+	v, err = candidates[2].Deserialize()
+	assert.Nil(t, err)
+	assert.Equal(t, "friend", v.(*TestSubOtherPackage).FieldL.Value)
+}
+
+func TestHostileFrameSize(t *testing.T) {
+	data := []byte{0x27, 0x24, 0x50, 0x01, 0x01, 0x04, 0x04, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}
+	buf, err := impl.SerializeMessage(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x02)
+	assert.Nil(t, err)
+	data = append(data, buf.Bytes()...)
+
+	d := impl.NewDeserializer(impl.DeserializerOptions{MaxPayloadSize: 1024})
+	candidates, _ := d.FeedBytes(data)
+	assert.True(t, errors.Is(d.LastError(), impl.ErrFrameTooLarge))
+	assert.Equal(t, 1, len(candidates))
+	assert.Equal(t, byte(0x02), candidates[0].MessageMeta.MessageID)
+
+	// Frames within the limit only grow their buffers as bytes arrive.
+	header := []byte{0x27, 0x24, 0x50, 0x01, 0x01, 0x04, 0x03, 0x00, 0x00, 0x00, 0x04}
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	impl.NewDeserializer(impl.DeserializerOptions{}).FeedBytes(header)
+	runtime.ReadMemStats(&after)
+	allocated := after.TotalAlloc - before.TotalAlloc
+	assert.True(t, allocated < 1<<20, "%d bytes allocated for header", allocated)
+}
+
+func TestDecoderLimits(t *testing.T) {
+	buf, err := impl.SerializeMessage(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x01)
+	assert.Nil(t, err)
+
+	dec := impl.NewDecoderWithOptions(buf, impl.DeserializerOptions{MaxStringLen: 3})
+	_, _, err = dec.Decode()
+	assert.True(t, errors.Is(err, impl.ErrFrameTooLarge))
+
+	frame := func(payload ...byte) *bytes.Reader {
+		data := []byte{0x27, 0x24, 0x50, 0x01, 0x01, OptionalPackage{}.LudwiegID(), 0x01, byte(len(payload))}
+		return bytes.NewReader(append(data, payload...))
+	}
+
+	// Compact arrays declare no items, so the limit is also enforced while
+	// items are decoded.
+	dec = impl.NewDecoderWithOptions(frame(0x21, 0x01, 0x05, 0x04, 0x00, 0x01, 0x02, 0x03, 0x04, 0x05), impl.DeserializerOptions{MaxArrayItems: 3})
+	_, _, err = dec.Decode()
+	assert.True(t, errors.Is(err, impl.ErrFrameTooLarge))
+
+	// Declared counts are not trusted beyond the data actually received.
+	dec = impl.NewDecoder(frame(0x21, 0x01, 0x01, 0x04, 0x03, 0x00, 0x00, 0x10, 0x00, 0x01))
+	_, _, err = dec.Decode()
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "array declares 1048576 items, found 1")
+	}
+}
+
+func TestDecoderMaxDepth(t *testing.T) {
+	// nested returns a struct holding depth levels of nested structs.
+	nested := func(depth int) []byte {
+		value := []byte{byte(impl.TypeStruct) | 0x2}
+		for i := 0; i < depth; i++ {
+			size := make([]byte, 2)
+			binary.LittleEndian.PutUint16(size, uint16(len(value)))
+			value = append(append([]byte{byte(impl.TypeStruct), 0x02}, size...), value...)
+		}
+		return value
+	}
+
+	_, err := impl.DeserializeNonMessage(nested(impl.DefaultMaxDepth+1), TestSubOther{})
+	assert.True(t, errors.Is(err, impl.ErrMaxDepth))
+
+	payload := nested(5)[4:]
+	data := []byte{0x27, 0x24, 0x50, 0x01, 0x01, TestSubOtherPackage{}.LudwiegID(), 0x01, byte(len(payload))}
+	dec := impl.NewDecoderWithOptions(bytes.NewReader(append(data, payload...)), impl.DeserializerOptions{MaxDepth: 3})
+	_, _, err = dec.Decode()
+	assert.True(t, errors.Is(err, impl.ErrMaxDepth))
+}
+
+func TestDeserializerResync(t *testing.T) {
+	buf, err := impl.SerializeMessage(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x01)
+	assert.Nil(t, err)
+	msg := buf.Bytes()
+
+	var data []byte
+	data = append(data, 0x00, 0x27, 0x27)
+	data = append(data, msg...)
+	data = append(data, 0x27, 0x24, 0x50, 0x02, 0x01, 0x04, 0x00)
+	data = append(data, 0x27, 0x24, 0x50, 0x01, 0x01, 0x04, 0x09)
+	data = append(data, msg...)
+
+	var errs []error
+	d := impl.NewDeserializer(impl.DeserializerOptions{
+		OnError: func(err error) { errs = append(errs, err) },
+	})
+	candidates, _ := d.FeedBytes(data)
+
+	assert.Equal(t, 2, len(candidates))
+	assert.Equal(t, 3, len(errs))
+	assert.True(t, errors.Is(errs[0], impl.ErrBadMagic))
+	// Remaining bytes of the dropped frame are discarded, but not reported
+	// again
+	assert.True(t, errors.Is(errs[1], impl.ErrUnsupportedVersion))
+	assert.True(t, errors.Is(errs[2], impl.ErrBadLengthEncoding))
+	assert.Equal(t, errs[2], d.LastError())
+	assert.Equal(t, uint64(2), d.DroppedFrames())
+	assert.Equal(t, uint64(3+4+3+7), d.DiscardedBytes())
+
+	// Decoders report why frames were dropped.
+	dec := impl.NewDecoderWithOptions(bytes.NewReader(msg), impl.DeserializerOptions{MaxPayloadSize: 4})
+	_, _, err = dec.Decode()
+	assert.True(t, errors.Is(err, impl.ErrFrameTooLarge))
+	_, _, err = dec.Decode()
+	assert.Equal(t, io.EOF, err)
+}
+
+func deserializeRaw(t *testing.T, packageType byte, payload []byte) (interface{}, error) {
+	data := []byte{0x27, 0x24, 0x50, 0x01, 0x01, packageType, 0x01, byte(len(payload))}
+	data = append(data, payload...)
+	candidates, _ := impl.NewDeserializer(impl.DeserializerOptions{}).FeedBytes(data)
+	if !assert.Equal(t, 1, len(candidates)) {
+		t.FailNow()
+	}
+	return candidates[0].Deserialize()
+}
+
+func TestMalformedPayloads(t *testing.T) {
+	_, err := deserializeRaw(t, 0x7f, []byte{0x04, 0x05})
+	assert.True(t, errors.Is(err, impl.ErrUnknownPackage))
+
+	_, err = deserializeRaw(t, 0x04, []byte{0x04, 0x05})
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+
+	_, err = deserializeRaw(t, 0x04, []byte{0x15, 0x09})
+	assert.True(t, errors.Is(err, impl.ErrBadLengthPrefix))
+
+	_, err = impl.DeserializeNonMessage([]byte{0x15, 0x01, 0x01, 0x41}, TestSubOther{})
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+
+	// Tagged values following untagged ones are rejected, rather than
+	// assigned to fields as they are.
+	_, err = deserializeRaw(t, 0x01, []byte{0x06, 0x58, 0x30, 0x30, 0x30, 0x30})
+	assert.NotNil(t, err)
+	_, err = deserializeRaw(t, 0x01, []byte{0x06, 0x58, 0x30, 0x30, 0x04, 0x05})
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+
+	// Array items declaring the empty bit would never consume any bytes.
+	hostileArray := []byte{0x2D, 0x01, 0x08, 0x21, 0x01, 0x02, 0x06, 0x01, 0x02, 0x01, 0x02}
+	_, err = impl.DeserializeNonMessage(hostileArray, OptionalPackage{})
+	assert.True(t, errors.Is(err, impl.ErrUnknownType))
+
+	// So would keys and values of maps.
+	hostileMap := []byte{0x2D, 0x01, 0x09, 0x55, 0x01, 0x02, 0x17, 0x06, 0x01, 0x02, 0x01, 0x02}
+	_, err = impl.DeserializeNonMessage(hostileMap, MapPackage{})
+	assert.True(t, errors.Is(err, impl.ErrUnknownType))
+
+	entry := []byte{0x01, 0x01, 'a', 0x01, 0x00, 0x00, 0x00}
+	mapPayload := func(declared byte, entries ...[]byte) []byte {
+		body := bytes.Join(entries, nil)
+		m := append([]byte{0x55, 0x01, byte(len(body)), 0x15, 0x08, 0x01, declared}, body...)
+		return append([]byte{0x2D, 0x01, byte(len(m))}, m...)
+	}
+	_, err = impl.DeserializeNonMessage(mapPayload(1, entry), MapPackage{})
+	assert.Nil(t, err)
+	_, err = impl.DeserializeNonMessage(mapPayload(2, entry), MapPackage{})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "map declares 2 entries, found 1")
+	}
+	_, err = impl.DeserializeNonMessage(mapPayload(2, entry, entry), MapPackage{})
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), "duplicate map key a")
+	}
+}
+
+func TestTruncatedPayload(t *testing.T) {
+	obj := Test{
+		FieldI: &TestSub{
+			FieldJ: impl.String("Structure"),
+			FieldK: &TestSubOther{
+				FieldL: impl.String("Other Structure"),
+			},
+		},
+		FieldZ: []*impl.LudwiegString{impl.String("Robin"), impl.String("Tom")},
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	// Locate the last string, and declare its length as larger than what
+	// remains in the payload.
+	idx := bytes.LastIndex(data, []byte("Other Structure"))
+	data[idx-1] = 0xff
+	offset := idx - 8
+
+	_, _, err = impl.NewDecoder(bytes.NewReader(data)).Decode()
+	var decodeErr *impl.DecodeError
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, "FieldI.FieldK.FieldL", decodeErr.Path)
+		assert.Equal(t, impl.TypeString, decodeErr.Type)
+		assert.Equal(t, offset, decodeErr.Offset)
+		assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+	}
+
+	buf, err = impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+	data = buf.Bytes()
+	idx = bytes.Index(data, []byte("Tom"))
+	data[idx-1] = 0xff
+
+	_, _, err = impl.NewDecoder(bytes.NewReader(data)).Decode()
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, "FieldZ[1]", decodeErr.Path)
+	}
+
+	_, err = impl.DeserializeNonMessage([]byte{0x2d, 0x1, 0x5, 0x15, 0x1, 0x5, 0x68, 0x65}, TestSubOther{})
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, "FieldL", decodeErr.Path)
+	}
+}
+
+func TestLengthEncodings(t *testing.T) {
+	long := strings.Repeat("ludwieg ", 40)
+	names := make([]*impl.LudwiegString, 100)
+	for i := range names {
+		names[i] = impl.String(long[:i])
+	}
+	blob := bytes.Repeat([]byte{0x27, 0x24, 0x50}, 30000)
+
+	obj := Test{
+		FieldA: impl.Uint8(27),
+		FieldE: impl.String(long),
+		FieldF: blob,
+		FieldZ: names,
+		FieldI: &TestSub{
+			FieldJ: impl.String(long),
+			FieldK: &TestSubOther{FieldL: impl.String(long)},
+		},
+	}
+
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+	v, _, err := impl.NewDecoder(buf).Decode()
+	if !assert.Nil(t, err) {
+		return
+	}
+	r := v.(*Test)
+	assert.Equal(t, uint8(27), r.FieldA.Value)
+	assert.Equal(t, long, r.FieldE.Value)
+	assert.Equal(t, blob, r.FieldF)
+	assert.Equal(t, len(names), len(r.FieldZ))
+	for i, n := range names {
+		assert.Equal(t, n.Value, r.FieldZ[i].Value)
+	}
+	assert.Equal(t, long, r.FieldI.FieldJ.Value)
+	assert.Equal(t, long, r.FieldI.FieldK.FieldL.Value)
+
+	// Values this small are never encoded using 64-bit sizes, but decoders
+	// must still accept them.
+	uint64Size := func(v uint64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, v)
+		return append([]byte{0x04}, b...)
+	}
+	data := []byte{0x2d}
+	data = append(data, uint64Size(15)...)
+	data = append(data, 0x15)
+	data = append(data, uint64Size(5)...)
+	data = append(data, []byte("hello")...)
+	res, err := impl.DeserializeNonMessage(data, TestSubOther{})
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", res.(*TestSubOther).FieldL.Value)
+}
+
+func TestMarshaler(t *testing.T) {
+	obj := MarshaledPackage{
+		FieldA: impl.String("hello"),
+		FieldC: []*CustomType{{impl.String("a")}, {impl.String("b")}},
+		FieldD: &TestSubOther{FieldL: impl.String("other")},
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+
+	reflected, err := impl.SerializeMessage(ReflectedPackage(obj), 0x01)
+	assert.Nil(t, err)
+	assert.Equal(t, reflected.Bytes(), buf.Bytes())
+
+	unmarshaledPackages = 0
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, unmarshaledPackages)
+	r := v.(*MarshaledPackage)
+	assert.Equal(t, "hello", r.FieldA.Value)
+	assert.False(t, r.FieldB.HasValue)
+	assert.Len(t, r.FieldC, 2)
+	assert.Equal(t, "b", r.FieldC[1].FieldV.Value)
+	assert.Equal(t, "other", r.FieldD.FieldL.Value)
+
+	nonMessage, err := impl.SerializeNonMessage(obj)
+	assert.Nil(t, err)
+	res, err := impl.DeserializeNonMessage(nonMessage.Bytes(), MarshaledPackage{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, unmarshaledPackages)
+	assert.Equal(t, "hello", res.(*MarshaledPackage).FieldA.Value)
+}
+
+func TestUnmarshalerSchemaMismatch(t *testing.T) {
+	buf, err := impl.SerializeMessage(TestSubOtherPackage{FieldL: impl.String("hello")}, 0x01)
+	assert.Nil(t, err)
+	payload := buf.Bytes()[8:]
+
+	// Uint32 where a String is expected.
+	payload[0] = byte(impl.TypeUint32)
+	_, err = deserializeRaw(t, MarshaledPackage{}.LudwiegID(), payload)
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+	var decodeErr *impl.DecodeError
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, "FieldA", decodeErr.Path)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	reg := impl.NewRegistry()
+	assert.Nil(t, reg.Register(ReflectedPackage{}))
+	assert.NotNil(t, reg.Register(MarshaledPackage{}))
+
+	typ, ok := reg.Lookup(ReflectedPackage{}.LudwiegID())
+	assert.True(t, ok)
+	assert.Equal(t, "ReflectedPackage", typ.Name())
+	_, ok = reg.Lookup(Test{}.LudwiegID())
+	assert.False(t, ok)
+
+	// Packages sharing an ID with the default registry are decoded according
+	// to the provided registry.
+	buf, err := impl.SerializeMessage(MarshaledPackage{FieldA: impl.String("hello")}, 0x01)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+	v, _, err := impl.NewDecoderWithOptions(bytes.NewReader(data), impl.DeserializerOptions{Registry: reg}).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", v.(*ReflectedPackage).FieldA.Value)
+
+	reg.Unregister(ReflectedPackage{}.LudwiegID())
+	_, _, err = impl.NewDecoderWithOptions(bytes.NewReader(data), impl.DeserializerOptions{Registry: reg}).Decode()
+	assert.True(t, errors.Is(err, impl.ErrUnknownPackage))
+}
+
+type MismatchedSub struct {
+	FieldA *impl.LudwiegString
+}
+
+func (t MismatchedSub) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{{Type: impl.TypeUint8}}
+}
+
+type MismatchedPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB []*impl.LudwiegString
+	FieldC []*CustomType
+	FieldD *MismatchedSub
+	FieldE *impl.LudwiegBool
+}
+
+func (t MismatchedPackage) LudwiegID() byte { return 0x07 }
+func (t MismatchedPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeUint32},
+		{Type: impl.TypeArray, ArraySize: "*", ArrayType: impl.TypeUint8},
+		impl.ArrayOf(TestSubOther{}),
+		{Type: impl.TypeStruct},
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	assert.Nil(t, impl.ValidateSchema(Test{}))
+	assert.Nil(t, impl.ValidateSchema(&MarshaledPackage{}))
+
+	err := impl.ValidateSchema(MismatchedPackage{})
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+	var schemaErr *impl.SchemaError
+	if assert.True(t, errors.As(err, &schemaErr)) {
+		assert.Equal(t, []string{
+			"MismatchedPackage: 4 annotations for 5 fields",
+			"MismatchedPackage.FieldA: expected *impl.LudwiegUint32, found *impl.LudwiegString",
+			"MismatchedPackage.FieldB: expected items of type *impl.LudwiegUint8, found *impl.LudwiegString",
+			"MismatchedPackage.FieldC: ArrayUserType *main.TestSubOther does not match items of type *main.CustomType",
+			"MismatchedSub.FieldA: expected *impl.LudwiegUint8, found *impl.LudwiegString",
+		}, schemaErr.Mismatches)
+	}
+
+	assert.True(t, errors.Is(impl.NewRegistry().Register(MismatchedPackage{}), impl.ErrSchemaMismatch))
+	assert.Panics(t, func() { impl.RegisterPackages(MismatchedPackage{}) })
+}
+
+type IntegersPackage struct {
+	FieldA *impl.LudwiegUint16
+	FieldB *impl.LudwiegInt8
+	FieldC *impl.LudwiegInt16
+	FieldD *impl.LudwiegInt32
+	FieldE *impl.LudwiegInt64
+	FieldF []*impl.LudwiegInt32
+	FieldG *impl.LudwiegAny
+	FieldH *impl.LudwiegAny
+}
+
+func (t IntegersPackage) LudwiegID() byte { return 0x08 }
+func (t IntegersPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeUint16},
+		{Type: impl.TypeInt8},
+		{Type: impl.TypeInt16},
+		{Type: impl.TypeInt32},
+		{Type: impl.TypeInt64},
+		{Type: impl.TypeArray, ArraySize: "*", ArrayType: impl.TypeInt32},
+		{Type: impl.TypeAny},
+		{Type: impl.TypeAny},
+	}
+}
+
+func TestIntegerTypes(t *testing.T) {
+	obj := IntegersPackage{
+		FieldA: impl.Uint16(65535),
+		FieldB: impl.Int8(-128),
+		FieldC: impl.Int16(-32768),
+		FieldD: impl.Int32(-2147483648),
+		FieldE: impl.Int64(-9223372036854775808),
+		FieldF: []*impl.LudwiegInt32{impl.Int32(-1), impl.Int32(2)},
+		FieldG: impl.Any(impl.Int64(-27)),
+		FieldH: impl.Any([]*impl.LudwiegInt16{impl.Int16(-3)}),
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	r := v.(*IntegersPackage)
+	assert.Equal(t, uint16(65535), r.FieldA.Value)
+	assert.Equal(t, int8(-128), r.FieldB.Value)
+	assert.Equal(t, int16(-32768), r.FieldC.Value)
+	assert.Equal(t, int32(-2147483648), r.FieldD.Value)
+	assert.Equal(t, int64(-9223372036854775808), r.FieldE.Value)
+	assert.Equal(t, obj.FieldF, r.FieldF)
+	assert.Equal(t, int64(-27), r.FieldG.Value.(*impl.LudwiegInt64).Value)
+	items := r.FieldH.Value.([]*impl.LudwiegInt16)
+	assert.Equal(t, int16(-3), items[0].Value)
+}
+
+type DynIntHolder struct {
+	FieldA *impl.LudwiegDynInt
+}
+
+func (t DynIntHolder) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{{Type: impl.TypeDynInt}}
+}
+
+func TestDynInt(t *testing.T) {
+	roundTrip := func(v interface{}) *impl.LudwiegDynInt {
+		buf, err := impl.SerializeNonMessage(DynIntHolder{impl.DynInt(v)})
+		assert.Nil(t, err)
+		res, err := impl.DeserializeNonMessage(buf.Bytes(), DynIntHolder{})
+		assert.Nil(t, err)
+		return res.(*DynIntHolder).FieldA
+	}
+
+	d := roundTrip(int8(-1))
+	assert.Equal(t, impl.DynIntValueKindInt8, d.UnderlyingType)
+	i8, err := d.Int8()
+	assert.Nil(t, err)
+	assert.Equal(t, int8(-1), i8)
+	_, err = d.Uint8()
+	assert.True(t, errors.Is(err, impl.ErrDynIntOverflow))
+
+	d = roundTrip(int64(math.MinInt64))
+	assert.Equal(t, impl.DynIntValueKindInt64, d.UnderlyingType)
+	i64, err := d.Int64()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(math.MinInt64), i64)
+
+	d = roundTrip(uint64(math.MaxUint64))
+	assert.Equal(t, impl.DynIntValueKindUint64, d.UnderlyingType)
+	u64, err := d.Uint64()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(math.MaxUint64), u64)
+	_, err = d.Int64()
+	assert.True(t, errors.Is(err, impl.ErrDynIntOverflow))
+
+	d = roundTrip(uint64(1<<53 + 1))
+	u64, err = d.Uint64()
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1<<53+1), u64)
+
+	d = roundTrip(300)
+	assert.Equal(t, impl.DynIntValueKindUint16, d.UnderlyingType)
+	_, err = d.Int8()
+	assert.True(t, errors.Is(err, impl.ErrDynIntOverflow))
+
+	d = roundTrip(float32(1.5))
+	assert.Equal(t, impl.DynIntValueKindFloat32, d.UnderlyingType)
+	f32, err := d.Float32()
+	assert.Nil(t, err)
+	assert.Equal(t, float32(1.5), f32)
+	_, err = d.Int()
+	assert.True(t, errors.Is(err, impl.ErrDynIntOverflow))
+
+	d = roundTrip(0.1)
+	assert.Equal(t, impl.DynIntValueKindFloat64, d.UnderlyingType)
+	f64, err := d.Float64()
+	assert.Nil(t, err)
+	assert.Equal(t, 0.1, f64)
+	_, err = roundTrip(math.MaxFloat64).Float32()
+	assert.True(t, errors.Is(err, impl.ErrDynIntOverflow))
+
+	// Float32 values are encoded using 4 bytes.
+	buf, err := impl.SerializeNonMessage(DynIntHolder{impl.DynInt(float32(1.5))})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x2d, 0x1, 0x6, 0x30, byte(impl.DynIntValueKindFloat32), 0x0, 0x0, 0xc0, 0x3f}, buf.Bytes())
+
+	// Values of unknown kinds are blamed on the DynInt field itself.
+	data := buf.Bytes()
+	data[4] = 0x33
+	_, err = impl.DeserializeNonMessage(data, DynIntHolder{})
+	var decodeErr *impl.DecodeError
+	if assert.True(t, errors.As(err, &decodeErr)) {
+		assert.Equal(t, impl.TypeDynInt, decodeErr.Type)
+		assert.True(t, errors.Is(err, impl.ErrUnknownType))
+	}
+}
+
+type FloatPackage struct {
+	FieldA *impl.LudwiegFloat
+	FieldB []*impl.LudwiegFloat
+	FieldC *impl.LudwiegAny
+}
+
+func (t FloatPackage) LudwiegID() byte { return 0x09 }
+func (t FloatPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeFloat},
+		{Type: impl.TypeArray, ArraySize: "*", ArrayType: impl.TypeFloat},
+		{Type: impl.TypeAny},
+	}
+}
+
+func TestFloatType(t *testing.T) {
+	obj := FloatPackage{
+		FieldA: impl.Float(-1.5),
+		FieldB: []*impl.LudwiegFloat{impl.Float(math.MaxFloat32), impl.Float(0.1)},
+		FieldC: impl.Any(impl.Float(27.5)),
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+	// Floats are encoded using 4 bytes.
+	assert.Equal(t, []byte{0x48, 0x00, 0x00, 0xc0, 0xbf}, buf.Bytes()[8:13])
+
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	r := v.(*FloatPackage)
+	assert.Equal(t, float32(-1.5), r.FieldA.Value)
+	assert.Equal(t, obj.FieldB, r.FieldB)
+	assert.Equal(t, float32(27.5), r.FieldC.Value.(*impl.LudwiegFloat).Value)
+}
+
+type TimePackage struct {
+	FieldA *impl.LudwiegTimestamp
+	FieldB *impl.LudwiegTimestamp
+	FieldC *impl.LudwiegDuration
+	FieldD []*impl.LudwiegDuration
+	FieldE *impl.LudwiegAny
+}
+
+func (t TimePackage) LudwiegID() byte { return 0x0A }
+func (t TimePackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeTimestamp},
+		{Type: impl.TypeTimestamp},
+		{Type: impl.TypeDuration},
+		{Type: impl.TypeArray, ArraySize: "*", ArrayType: impl.TypeDuration},
+		{Type: impl.TypeAny},
+	}
+}
+
+func TestTimeTypes(t *testing.T) {
+	utc := time.Date(2017, 10, 18, 12, 30, 15, 123456789, time.UTC)
+	zoned := time.Date(1969, 7, 20, 20, 17, 40, 1, time.FixedZone("BRT", -3*60*60))
+	obj := TimePackage{
+		FieldA: impl.Timestamp(utc),
+		FieldB: impl.Timestamp(zoned),
+		FieldC: impl.Duration(-1500 * time.Millisecond),
+		FieldD: []*impl.LudwiegDuration{impl.Duration(time.Hour), impl.Duration(time.Nanosecond)},
+		FieldE: impl.Any(impl.Duration(time.Minute)),
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	r := v.(*TimePackage)
+	assert.Equal(t, utc, r.FieldA.Value)
+	assert.Equal(t, time.UTC, r.FieldA.Value.Location())
+	assert.True(t, zoned.Equal(r.FieldB.Value))
+	_, zoneOffset := r.FieldB.Value.Zone()
+	assert.Equal(t, -3*60*60, zoneOffset)
+	assert.Equal(t, -1500*time.Millisecond, r.FieldC.Value)
+	assert.Equal(t, obj.FieldD, r.FieldD)
+	assert.Equal(t, time.Minute, r.FieldE.Value.(*impl.LudwiegDuration).Value)
+}
+
+type MapPackage struct {
+	FieldA map[string]*impl.LudwiegUint32
+	FieldB map[int32]*CustomType
+	FieldC map[bool]*impl.LudwiegString
+	FieldD map[string]*impl.LudwiegString
+}
+
+func (t MapPackage) LudwiegID() byte { return 0x0B }
+func (t MapPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		impl.MapOf(impl.TypeString, impl.TypeUint32),
+		impl.MapOfStruct(impl.TypeInt32, CustomType{}),
+		impl.MapOf(impl.TypeBool, impl.TypeString),
+		impl.MapOf(impl.TypeString, impl.TypeString),
+	}
+}
+
+func TestMapType(t *testing.T) {
+	obj := MapPackage{
+		FieldA: map[string]*impl.LudwiegUint32{"a": impl.Uint32(1), "b": impl.Uint32(2), "c": impl.Uint32(0)},
+		FieldB: map[int32]*CustomType{-1: {impl.String("minus one")}, 27: {impl.String("twenty-seven")}},
+		FieldC: map[bool]*impl.LudwiegString{},
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+
+	// Maps are always serialized into the same bytes.
+	for i := 0; i < 10; i++ {
+		again, err := impl.SerializeMessage(obj, 0x01)
+		assert.Nil(t, err)
+		assert.Equal(t, buf.Bytes(), again.Bytes())
+	}
+
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	r := v.(*MapPackage)
+	assert.Equal(t, obj.FieldA, r.FieldA)
+	assert.Equal(t, obj.FieldB, r.FieldB)
+	assert.NotNil(t, r.FieldC)
+	assert.Empty(t, r.FieldC)
+	assert.Nil(t, r.FieldD)
+
+	_, err = impl.SerializeMessage(MapPackage{FieldD: map[string]*impl.LudwiegString{"nil": nil}}, 0x01)
+	assert.NotNil(t, err)
+}
+
+type MapArrayPackage struct {
+	FieldA []map[string]*impl.LudwiegString
+	FieldB []map[int32]*CustomType
+}
+
+func (t MapArrayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		impl.ArrayOf(impl.MapOf(impl.TypeString, impl.TypeString)),
+		impl.ArrayOf(impl.MapOfStruct(impl.TypeInt32, CustomType{})),
+	}
+}
+
+func TestMapsInArrays(t *testing.T) {
+	assert.Nil(t, impl.ValidateSchema(MapArrayPackage{}))
+
+	obj := MapArrayPackage{
+		FieldA: []map[string]*impl.LudwiegString{{"a": impl.String("b")}, {}},
+		FieldB: []map[int32]*CustomType{{27: {impl.String("twenty-seven")}}},
+	}
+	buf, err := impl.SerializeNonMessage(obj)
+	assert.Nil(t, err)
+	v, err := impl.DeserializeNonMessage(buf.Bytes(), MapArrayPackage{})
+	assert.Nil(t, err)
+	assert.Equal(t, &obj, v)
+}
+
+type MismatchedMapPackage struct {
+	FieldA map[int]*impl.LudwiegString
+	FieldB map[string]*impl.LudwiegString
+}
+
+func (t MismatchedMapPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		impl.MapOf(impl.TypeInt64, impl.TypeString),
+		impl.MapOf(impl.TypeDouble, impl.TypeString),
+	}
+}
+
+func TestMapSchema(t *testing.T) {
+	assert.Nil(t, impl.ValidateSchema(MapPackage{}))
+
+	var schemaErr *impl.SchemaError
+	if assert.True(t, errors.As(impl.ValidateSchema(MismatchedMapPackage{}), &schemaErr)) {
+		assert.Equal(t, []string{
+			"MismatchedMapPackage.FieldA: expected keys of type int64, found int",
+			"MismatchedMapPackage.FieldB: unsupported map key type Double",
+		}, schemaErr.Mismatches)
+	}
+}
+
+type FixedArrayPackage struct {
+	FieldA []*impl.LudwiegString
+	FieldB []*CustomType
+}
+
+func (t FixedArrayPackage) LudwiegID() byte { return 0x0C }
+func (t FixedArrayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	compact := impl.ArrayOfWithSize(CustomType{}, "1")
+	compact.ArrayCompact = true
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeArray, ArraySize: "2", ArrayType: impl.TypeString},
+		compact,
+	}
+}
+
+// UnsizedArrayPackage shares its ID with FixedArrayPackage, producing
+// payloads with arbitrary array sizes.
+type UnsizedArrayPackage struct {
+	FieldA []*impl.LudwiegString
+	FieldB []*CustomType
+}
+
+func (t UnsizedArrayPackage) LudwiegID() byte { return 0x0C }
+func (t UnsizedArrayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeArray, ArraySize: "*", ArrayType: impl.TypeString},
+		impl.ArrayOf(CustomType{}),
+	}
+}
+
+func TestFixedSizeArrays(t *testing.T) {
+	obj := FixedArrayPackage{
+		FieldA: []*impl.LudwiegString{impl.String("a"), impl.String("b")},
+		FieldB: []*CustomType{{impl.String("c")}},
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+	unsized, err := impl.SerializeMessage(UnsizedArrayPackage(obj), 0x01)
+	assert.Nil(t, err)
+	// Compact arrays omit their item count.
+	assert.Equal(t, unsized.Len()-1, buf.Len())
+
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	r := v.(*FixedArrayPackage)
+	assert.Equal(t, obj.FieldA, r.FieldA)
+	assert.Equal(t, "c", r.FieldB[0].FieldV.Value)
+
+	_, err = impl.SerializeMessage(FixedArrayPackage{FieldA: obj.FieldA[:1], FieldB: obj.FieldB}, 0x01)
+	assert.EqualError(t, err, "fixed-size array expects 2 items, found 1")
+	_, err = impl.SerializeMessage(FixedArrayPackage{FieldB: obj.FieldB}, 0x01)
+	assert.EqualError(t, err, "fixed-size array expects 2 items, found 0")
+
+	buf, err = impl.SerializeMessage(UnsizedArrayPackage{FieldA: obj.FieldA, FieldB: []*CustomType{}}, 0x01)
+	assert.Nil(t, err)
+	_, _, err = impl.NewDecoder(buf).Decode()
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+	assert.Contains(t, err.Error(), "FixedArrayPackage.FieldB: fixed-size array expects 1 items, found 0")
+}
+
+func TestArrayCountMismatch(t *testing.T) {
+	buf, err := impl.SerializeMessage(Test{FieldZ: []*impl.LudwiegString{impl.String("a")}}, 0x01)
+	assert.Nil(t, err)
+	payload := buf.Bytes()[8:]
+	// Array prelude: type, size, item type, then item count.
+	prelude := bytes.Index(payload, []byte{byte(impl.TypeArray), 0x1, 0x3, byte(impl.TypeString), 0x1, 0x1})
+	if !assert.True(t, prelude >= 0) {
+		return
+	}
+
+	// Only compact arrays may declare no items.
+	for _, count := range []byte{2, 0} {
+		payload[prelude+5] = count
+		_, err = deserializeRaw(t, Test{}.LudwiegID(), payload)
+		var decodeErr *impl.DecodeError
+		if assert.True(t, errors.As(err, &decodeErr)) {
+			assert.Equal(t, "FieldZ", decodeErr.Path)
+			assert.EqualError(t, decodeErr.Err, fmt.Sprintf("array declares %d items, found 1", count))
+		}
+	}
+}
+
+type MatrixPackage struct {
+	FieldA [][]*impl.LudwiegDouble
+	FieldB [][]*CustomType
+	FieldC [][][]*impl.LudwiegString
+}
+
+func (t MatrixPackage) LudwiegID() byte { return 0x0D }
+func (t MatrixPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		impl.ArrayOf(impl.ArrayOfWithSize(impl.TypeDouble, "2")),
+		impl.ArrayOf(impl.ArrayOf(CustomType{})),
+		impl.ArrayOf(impl.ArrayOf(impl.ArrayOf(impl.TypeString))),
+	}
+}
+
+func TestNestedArrays(t *testing.T) {
+	assert.Nil(t, impl.ValidateSchema(MatrixPackage{}))
+
+	obj := MatrixPackage{
+		FieldA: [][]*impl.LudwiegDouble{
+			{impl.Double(1), impl.Double(0)},
+			{impl.Double(0), impl.Double(1)},
+		},
+		FieldB: [][]*CustomType{{{impl.String("a")}}, {}, {{impl.String("b")}, {impl.String("c")}}},
+		FieldC: [][][]*impl.LudwiegString{{{impl.String("deep")}, nil}},
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	r := v.(*MatrixPackage)
+	assert.Equal(t, obj.FieldA, r.FieldA)
+	assert.Equal(t, obj.FieldB, r.FieldB)
+	// Nil inner arrays are decoded as empty ones.
+	assert.Equal(t, [][][]*impl.LudwiegString{{{impl.String("deep")}, {}}}, r.FieldC)
+
+	obj.FieldA[1] = obj.FieldA[1][:1]
+	_, err = impl.SerializeMessage(obj, 0x01)
+	assert.EqualError(t, err, "fixed-size array expects 2 items, found 1")
+
+	// Sizes of inner arrays are checked when decoding, too.
+	buf, err = impl.SerializeMessage(LooseMatrixPackage{FieldA: [][]*impl.LudwiegDouble{{impl.Double(1)}}}, 0x01)
+	assert.Nil(t, err)
+	_, _, err = impl.NewDecoder(buf).Decode()
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+}
+
+// LooseMatrixPackage is a revision of MatrixPackage lacking fixed sizes,
+// sharing its ID.
+type LooseMatrixPackage struct {
+	FieldA [][]*impl.LudwiegDouble
+}
+
+func (t LooseMatrixPackage) LudwiegID() byte { return 0x0D }
+func (t LooseMatrixPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{impl.ArrayOf(impl.ArrayOf(impl.TypeDouble))}
+}
+
+type MismatchedMatrix struct {
+	FieldA [][]*impl.LudwiegDouble
+}
+
+func (t MismatchedMatrix) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		impl.ArrayOf(impl.ArrayOf(impl.TypeFloat)),
+	}
+}
+
+func TestNestedArraySchema(t *testing.T) {
+	var schemaErr *impl.SchemaError
+	if assert.True(t, errors.As(impl.ValidateSchema(MismatchedMatrix{}), &schemaErr)) {
+		assert.Equal(t, []string{
+			"MismatchedMatrix.FieldA[]: expected items of type *impl.LudwiegFloat, found *impl.LudwiegDouble",
+		}, schemaErr.Mismatches)
+	}
+}
+
+type AnyPayload struct {
+	FieldA *impl.LudwiegString
+	FieldB *TestSubOther
+}
+
+func (t AnyPayload) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString},
+		{Type: impl.TypeStruct},
+	}
+}
+
+func TestRegisteredStructInAny(t *testing.T) {
+	payload := &AnyPayload{
+		FieldA: impl.String("hello"),
+		FieldB: &TestSubOther{FieldL: impl.String("other")},
+	}
+	for _, value := range []interface{}{
+		payload,
+		[]*AnyPayload{payload, {FieldA: impl.String("friend")}},
+	} {
+		buf, err := impl.SerializeMessage(AnyTestStruct{FieldP: impl.Any(value)}, 0x01)
+		assert.Nil(t, err)
+		data := buf.Bytes()
+
+		v, _, err := impl.NewDecoder(bytes.NewReader(data)).Decode()
+		assert.Nil(t, err)
+		assert.Equal(t, value, v.(*AnyTestStruct).FieldP.Value)
+
+		// Registries without the struct cannot rebuild it.
+		reg := impl.NewRegistry()
+		assert.Nil(t, reg.Register(AnyTestStruct{}))
+		_, _, err = impl.NewDecoderWithOptions(bytes.NewReader(data), impl.DeserializerOptions{Registry: reg}).Decode()
+		assert.True(t, errors.Is(err, impl.ErrUnknownType))
+	}
+
+	// Nil structs cannot be told apart from missing ones, and are rejected.
+	_, err := impl.SerializeMessage(AnyTestStruct{FieldP: impl.Any((*AnyPayload)(nil))}, 0x01)
+	assert.NotNil(t, err)
+
+	// Structs registered on other registries are encoded and decoded through
+	// them.
+	reg := impl.NewRegistry()
+	assert.Nil(t, reg.Register(AnyTestStruct{}))
+	assert.Nil(t, reg.RegisterAnyType(0x0201, CustomType{}))
+	value := []*CustomType{{impl.String("hello")}}
+	_, err = impl.SerializeMessage(AnyTestStruct{FieldP: impl.Any(value)}, 0x01)
+	assert.NotNil(t, err)
+	var buf bytes.Buffer
+	assert.Nil(t, impl.NewEncoderWithOptions(&buf, impl.EncoderOptions{Registry: reg}).Encode(AnyTestStruct{FieldP: impl.Any(value)}, 0x01))
+	v, _, err := impl.NewDecoderWithOptions(&buf, impl.DeserializerOptions{Registry: reg}).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, value, v.(*AnyTestStruct).FieldP.Value)
+
+	assert.Panics(t, func() { impl.RegisterAnyType(0x0101, CustomType{}) })
+	assert.Panics(t, func() { impl.RegisterAnyType(0x0102, AnyPayload{}) })
+}
+
+func TestAnyRoundTrip(t *testing.T) {
+	stamp := time.Date(2024, 2, 29, 13, 37, 0, 42, time.UTC)
+	payload := &AnyPayload{FieldA: impl.String("hello")}
+	for name, value := range map[string]interface{}{
+		"Uint8":        impl.Uint8(27),
+		"Uint16":       impl.Uint16(2724),
+		"Uint32":       impl.Uint32(272450),
+		"Uint64":       impl.Uint64(27245027245027),
+		"Int8":         impl.Int8(-27),
+		"Int16":        impl.Int16(-2724),
+		"Int32":        impl.Int32(-272450),
+		"Int64":        impl.Int64(-27245027245027),
+		"Float":        impl.Float(2.5),
+		"Double":       impl.Double(27.245),
+		"String":       impl.String("hello"),
+		"Blob":         []byte{0x27, 0x24, 0x50},
+		"Bool":         impl.Bool(true),
+		"UUID":         impl.UUID(mustParseUUID("3232ee42c2f24baf841318335b4d5640")),
+		"Any":          impl.Any(impl.String("inner")),
+		"DynInt":       impl.DynInt(-300),
+		"Timestamp":    impl.Timestamp(stamp),
+		"Duration":     impl.Duration(90 * time.Second),
+		"Struct":       payload,
+		"Map":          map[string]*impl.LudwiegUint32{"a": impl.Uint32(1), "b": impl.Uint32(2)},
+		"Array":        []*impl.LudwiegString{impl.String("a"), impl.String("b")},
+		"BoolArray":    []*impl.LudwiegBool{impl.Bool(true), impl.Bool(false)},
+		"DynIntArray":  []*impl.LudwiegDynInt{impl.DynInt(1), impl.DynInt(3.5)},
+		"BlobArray":    [][]byte{{0x01}, {0x02, 0x03}},
+		"AnyArray":     []*impl.LudwiegAny{impl.Any(impl.Uint8(1)), impl.Any(impl.String("b"))},
+		"StructArray":  []*AnyPayload{payload},
+		"NestedArray":  [][]*impl.LudwiegInt32{{impl.Int32(1)}, {}, {impl.Int32(2), impl.Int32(3)}},
+		"TimestampMap": map[uint16]*impl.LudwiegTimestamp{27: impl.Timestamp(stamp)},
+		"EmptyString":  impl.String(""),
+	} {
+		buf, err := impl.SerializeMessage(AnyTestStruct{FieldP: impl.Any(value)}, 0x01)
+		if !assert.Nil(t, err, name) {
+			continue
+		}
+		v, _, err := impl.NewDecoder(buf).Decode()
+		if assert.Nil(t, err, name) {
+			assert.Equal(t, value, v.(*AnyTestStruct).FieldP.Value, name)
+		}
+	}
+
+	_, err := impl.SerializeMessage(AnyTestStruct{FieldP: impl.Any(map[string]*impl.LudwiegUint8{})}, 0x01)
+	assert.NotNil(t, err)
+	_, err = impl.SerializeMessage(AnyTestStruct{FieldP: impl.Any(nil)}, 0x01)
+	assert.NotNil(t, err)
+}
+
+func mustParseUUID(s string) impl.UUIDValue {
+	u, err := impl.ParseUUID(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestUUID(t *testing.T) {
+	u, err := impl.ParseUUID("0A0B0C0D-0001-4002-8003-000000000004")
+	assert.Nil(t, err)
+	assert.Equal(t, "0a0b0c0d-0001-4002-8003-000000000004", u.String())
+	assert.Equal(t, u, mustParseUUID("0a0b0c0d000140028003000000000004"))
+	assert.Equal(t, &impl.LudwiegUUID{HasValue: true, Value: u}, impl.UUID(u))
+
+	for _, invalid := range []string{"", "0a0b0c0d", "0a0b0c0d-0001-4002-8003-00000000000g", "0a0b0c0d00-01-4002-8003-000000000004"} {
+		_, err := impl.ParseUUID(invalid)
+		assert.NotNil(t, err, invalid)
+	}
+
+	random, err := impl.NewUUID()
+	assert.Nil(t, err)
+	assert.Equal(t, byte(0x40), random[6]&0xf0)
+	assert.Equal(t, byte(0x80), random[8]&0xc0)
+
+	// Bytes lower than 0x10 must survive a round trip.
+	value := map[impl.UUIDValue]*impl.LudwiegUUID{
+		random: {HasValue: true, Value: u},
+		u:      {HasValue: true, Value: random},
+	}
+	buf, err := impl.SerializeMessage(AnyTestStruct{FieldP: impl.Any(value)}, 0x01)
+	assert.Nil(t, err)
+	v, _, err := impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, value, v.(*AnyTestStruct).FieldP.Value)
+}
+
+type OptionalPackage struct {
+	FieldA []*impl.LudwiegString
+	FieldB []byte
+	FieldC [][]byte
+}
+
+func (t OptionalPackage) LudwiegID() byte { return 0x0E }
+func (t OptionalPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		impl.ArrayOf(impl.TypeString),
+		{Type: impl.TypeBlob},
+		impl.ArrayOf(impl.TypeBlob),
+	}
+}
+
+func TestNilAndEmptyCollections(t *testing.T) {
+	roundTrip := func(obj OptionalPackage) *OptionalPackage {
+		buf, err := impl.SerializeMessage(obj, 0x01)
+		assert.Nil(t, err)
+		v, _, err := impl.NewDecoder(buf).Decode()
+		assert.Nil(t, err)
+		return v.(*OptionalPackage)
+	}
+
+	r := roundTrip(OptionalPackage{})
+	assert.Nil(t, r.FieldA)
+	assert.Nil(t, r.FieldB)
+	assert.Nil(t, r.FieldC)
+
+	r = roundTrip(OptionalPackage{FieldA: []*impl.LudwiegString{}, FieldB: []byte{}, FieldC: [][]byte{}})
+	assert.NotNil(t, r.FieldA)
+	assert.Empty(t, r.FieldA)
+	assert.NotNil(t, r.FieldB)
+	assert.Empty(t, r.FieldB)
+	assert.NotNil(t, r.FieldC)
+	assert.Empty(t, r.FieldC)
+
+	// Array items cannot be nil, hence nil blobs are written as empty ones.
+	r = roundTrip(OptionalPackage{FieldC: [][]byte{nil, {0x27}}})
+	assert.Equal(t, [][]byte{{}, {0x27}}, r.FieldC)
+
+	// Other nil items are rejected.
+	_, err := impl.SerializeMessage(OptionalPackage{FieldA: []*impl.LudwiegString{impl.String("a"), nil}}, 0x01)
+	assert.NotNil(t, err)
+}
+
+type TaggedPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB *impl.LudwiegUint32
+	FieldC *TaggedPackage
+}
+
+func (t TaggedPackage) LudwiegID() byte { return 0x0F }
+func (t TaggedPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString, Tag: 1},
+		{Type: impl.TypeUint32, Tag: 2},
+		{Type: impl.TypeStruct, Tag: 3},
+	}
+}
+
+// EvolvedTaggedPackage shares its ID with TaggedPackage, removing FieldB,
+// adding FieldD, and reordering remaining fields.
+type EvolvedTaggedPackage struct {
+	FieldD []*impl.LudwiegString
+	FieldA *impl.LudwiegString
+}
+
+func (t EvolvedTaggedPackage) LudwiegID() byte { return 0x0F }
+func (t EvolvedTaggedPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		impl.ArrayOf(impl.TypeString).WithTag(4),
+		{Type: impl.TypeString, Tag: 1},
+	}
+}
+
+type BadlyTaggedPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB *impl.LudwiegString
+}
+
+func (t BadlyTaggedPackage) LudwiegID() byte { return 0x10 }
+func (t BadlyTaggedPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString, Tag: 1},
+		{Type: impl.TypeString},
+	}
+}
+
+func TestTaggedFields(t *testing.T) {
+	obj := TaggedPackage{
+		FieldA: impl.String("hello"),
+		FieldB: impl.Uint32(27),
+		FieldC: &TaggedPackage{FieldA: impl.String("friend")},
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+
+	v, _, err := impl.NewDecoder(bytes.NewReader(data)).Decode()
+	assert.Nil(t, err)
+	r := v.(*TaggedPackage)
+	assert.Equal(t, obj.FieldA, r.FieldA)
+	assert.Equal(t, obj.FieldB, r.FieldB)
+	assert.Equal(t, obj.FieldC.FieldA, r.FieldC.FieldA)
+
+	// Peers using another revision of the package skip unknown fields,
+	// leaving missing ones nil.
+	reg := impl.NewRegistry()
+	assert.Nil(t, reg.Register(EvolvedTaggedPackage{}))
+	v, _, err = impl.NewDecoderWithOptions(bytes.NewReader(data), impl.DeserializerOptions{Registry: reg}).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, &EvolvedTaggedPackage{FieldA: impl.String("hello")}, v)
+
+	buf, err = impl.SerializeMessage(EvolvedTaggedPackage{
+		FieldD: []*impl.LudwiegString{impl.String("new")},
+		FieldA: impl.String("hello"),
+	}, 0x01)
+	assert.Nil(t, err)
+	v, _, err = impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, &TaggedPackage{FieldA: impl.String("hello")}, v)
+
+	// Unknown fields of unknown types are skipped through their length
+	// prefix.
+	buf, err = impl.SerializeNonMessage(&TaggedPackage{FieldA: impl.String("hello")})
+	assert.Nil(t, err)
+	data = buf.Bytes()
+	body := append(data[3:len(data):len(data)], byte(impl.TypeTag), 0x09, 0x00, (0x3F<<2)|0x1, 0x01, 0x02, 0x27, 0x24)
+	data = append([]byte{data[0], data[1], byte(len(body))}, body...)
+	v, err = impl.DeserializeNonMessage(data, TaggedPackage{})
+	assert.Nil(t, err)
+	assert.Equal(t, impl.String("hello"), v.(*TaggedPackage).FieldA)
+
+	// Tagged values cannot be decoded into untagged structs.
+	_, err = impl.DeserializeNonMessage(data, TestSubOther{})
+	assert.True(t, errors.Is(err, impl.ErrSchemaMismatch))
+
+	// Tagged structs implementing LudwiegMarshaler and LudwiegUnmarshaler are
+	// still written and read along with their tags.
+	reordered := impl.NewRegistry()
+	assert.Nil(t, reordered.Register(ReorderedTaggedPackage{}))
+	buf, err = impl.SerializeMessage(TaggedMarshaledPackage{FieldA: impl.String("hello"), FieldB: impl.Uint32(27)}, 0x01)
+	assert.Nil(t, err)
+	v, _, err = impl.NewDecoderWithOptions(buf, impl.DeserializerOptions{Registry: reordered}).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, &ReorderedTaggedPackage{FieldA: impl.String("hello"), FieldB: impl.Uint32(27)}, v)
+
+	buf, err = impl.SerializeMessage(v.(impl.SerializablePackage), 0x01)
+	assert.Nil(t, err)
+	v, _, err = impl.NewDecoder(buf).Decode()
+	assert.Nil(t, err)
+	assert.Equal(t, &TaggedMarshaledPackage{FieldA: impl.String("hello"), FieldB: impl.Uint32(27)}, v)
+
+	assert.NotNil(t, reg.Register(BadlyTaggedPackage{}))
+	assert.True(t, errors.Is(impl.ValidateSchema(BadlyTaggedPackage{}), impl.ErrSchemaMismatch))
+}
+
+type RelayPackage struct {
+	FieldA  *impl.LudwiegString
+	Unknown impl.LudwiegUnknownFields
+}
+
+func (t RelayPackage) LudwiegID() byte { return 0x11 }
+func (t RelayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{{Type: impl.TypeString}}
+}
+
+// NewerRelayPackage is a newer revision of RelayPackage, sharing its ID.
+type NewerRelayPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB *impl.LudwiegUint32
+	FieldC *TestSubOther
+}
+
+func (t NewerRelayPackage) LudwiegID() byte { return 0x11 }
+func (t NewerRelayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString},
+		{Type: impl.TypeUint32},
+		{Type: impl.TypeStruct},
+	}
+}
+
+type TaggedRelayPackage struct {
+	Unknown impl.LudwiegUnknownFields
+	FieldA  *impl.LudwiegString
+}
+
+func (t TaggedRelayPackage) LudwiegID() byte { return 0x12 }
+func (t TaggedRelayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{{Type: impl.TypeString, Tag: 1}}
+}
+
+// NewerTaggedRelayPackage is a newer revision of TaggedRelayPackage, sharing
+// its ID.
+type NewerTaggedRelayPackage struct {
+	FieldA *impl.LudwiegString
+	FieldB []*impl.LudwiegUint8
+}
+
+func (t NewerTaggedRelayPackage) LudwiegID() byte { return 0x12 }
+func (t NewerTaggedRelayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{
+		{Type: impl.TypeString, Tag: 1},
+		impl.ArrayOf(impl.TypeUint8).WithTag(2),
+	}
+}
+
+// MarshaledRelayPackage is a revision of RelayPackage implementing its own
+// marshaling.
+type MarshaledRelayPackage struct {
+	FieldA  *impl.LudwiegString
+	Unknown impl.LudwiegUnknownFields
+}
+
+func (t MarshaledRelayPackage) LudwiegID() byte { return 0x11 }
+func (t MarshaledRelayPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{{Type: impl.TypeString}}
+}
+
+func (t MarshaledRelayPackage) MarshalLudwieg(w *impl.Writer) error {
+	return w.WriteString(t.FieldA)
+}
+
+func (t *MarshaledRelayPackage) UnmarshalLudwieg(r *impl.Reader) (err error) {
+	t.FieldA, err = r.ReadString()
+	return err
+}
+
+type DoubleUnknownPackage struct {
+	FieldA   *impl.LudwiegString
+	UnknownA impl.LudwiegUnknownFields
+	UnknownB impl.LudwiegUnknownFields
+}
+
+func (t DoubleUnknownPackage) LudwiegID() byte { return 0x13 }
+func (t DoubleUnknownPackage) LudwiegMeta() []impl.LudwiegTypeAnnotation {
+	return []impl.LudwiegTypeAnnotation{{Type: impl.TypeString}}
+}
+
+func TestUnknownFieldsPassThrough(t *testing.T) {
+	newer := impl.NewRegistry()
+	assert.Nil(t, newer.Register(NewerRelayPackage{}, NewerTaggedRelayPackage{}))
+
+	for _, obj := range []impl.SerializablePackage{
+		&NewerRelayPackage{
+			FieldA: impl.String("hello"),
+			FieldB: impl.Uint32(27),
+			FieldC: &TestSubOther{FieldL: impl.String("friend")},
+		},
+		&NewerTaggedRelayPackage{
+			FieldA: impl.String("hello"),
+			FieldB: []*impl.LudwiegUint8{impl.Uint8(27), impl.Uint8(24)},
+		},
+	} {
+		buf, err := impl.SerializeMessage(obj, 0x01)
+		assert.Nil(t, err)
+		data := buf.Bytes()
+
+		// Relays using the older revision retain fields unknown to them,
+		// forwarding them unchanged.
+		v, _, err := impl.NewDecoder(bytes.NewReader(data)).Decode()
+		assert.Nil(t, err)
+		relayed := v.(impl.SerializablePackage)
+		switch r := relayed.(type) {
+		case *RelayPackage:
+			assert.Equal(t, "hello", r.FieldA.Value)
+			assert.NotEmpty(t, r.Unknown)
+		case *TaggedRelayPackage:
+			assert.Equal(t, "hello", r.FieldA.Value)
+			assert.NotEmpty(t, r.Unknown)
+		default:
+			t.Fatalf("unexpected %T", v)
+		}
+
+		buf, err = impl.SerializeMessage(relayed, 0x01)
+		assert.Nil(t, err)
+		assert.Equal(t, data, buf.Bytes())
+
+		v, _, err = impl.NewDecoderWithOptions(bytes.NewReader(buf.Bytes()), impl.DeserializerOptions{Registry: newer}).Decode()
+		assert.Nil(t, err)
+		assert.Equal(t, obj, v)
+	}
+
+	// Relays implementing their own marshaling retain unknown fields too.
+	marshaled := impl.NewRegistry()
+	assert.Nil(t, marshaled.Register(MarshaledRelayPackage{}))
+	obj := &NewerRelayPackage{
+		FieldA: impl.String("hello"),
+		FieldB: impl.Uint32(27),
+		FieldC: &TestSubOther{FieldL: impl.String("friend")},
+	}
+	buf, err := impl.SerializeMessage(obj, 0x01)
+	assert.Nil(t, err)
+	data := buf.Bytes()
+	v, _, err := impl.NewDecoderWithOptions(bytes.NewReader(data), impl.DeserializerOptions{Registry: marshaled}).Decode()
+	assert.Nil(t, err)
+	relayed := v.(*MarshaledRelayPackage)
+	assert.Equal(t, "hello", relayed.FieldA.Value)
+	assert.NotEmpty(t, relayed.Unknown)
+	buf, err = impl.SerializeMessage(relayed, 0x01)
+	assert.Nil(t, err)
+	assert.Equal(t, data, buf.Bytes())
+
+	assert.Nil(t, impl.ValidateSchema(RelayPackage{}))
+	assert.True(t, errors.Is(impl.ValidateSchema(DoubleUnknownPackage{}), impl.ErrSchemaMismatch))
+}
+
+func BenchmarkStructArray(b *testing.B) {
+	items := make([]*CustomType, 5000)
+	for i := range items {
+		items[i] = &CustomType{impl.String("hello")}
+	}
+	obj := Test{FieldZA: items}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, err := impl.SerializeMessage(obj, 0x01)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, _, err := impl.NewDecoder(buf).Decode(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}